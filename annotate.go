@@ -0,0 +1,40 @@
+package indexer
+
+// Annotator is implemented by indexers whose packages can carry metadata.
+type Annotator interface {
+	Annotate(name string, m *Metadata) string
+	Describe(name string) *Pkg
+}
+
+// Annotate replaces the metadata of the indexed package name with m. A nil m clears the metadata.
+// It returns OK if the metadata is replaced, or if it is the same already.
+// It returns Fail if name isn't indexed.
+func (i *InMemoryIndexer) Annotate(name string, m *Metadata) string {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	locked := i.registry.lockIndexed(name, true)
+	defer i.registry.unlock(locked)
+
+	p, exist := i.registry.lookup(name)
+	if !exist {
+		return Fail
+	}
+
+	if !sameMeta(p.Meta, m) {
+		i.put(name, &Pkg{Name: name, Deps: p.Deps, Meta: m})
+	}
+	return OK
+}
+
+// Describe returns the indexed package name with its metadata, or nil if name isn't indexed.
+func (i *InMemoryIndexer) Describe(name string) *Pkg {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	locked := i.registry.lockIndexed(name, false)
+	defer i.registry.unlock(locked)
+
+	p, _ := i.registry.lookup(name)
+	return p
+}
@@ -0,0 +1,104 @@
+package indexer
+
+import "testing"
+
+func TestAnnotate(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	meta := &Metadata{Version: "1.2.11", Labels: map[string]string{"team": "core"}}
+	fixture.Index(&Pkg{Name: "zlib", Meta: meta})
+	fixture.Index(&Pkg{Name: "libpng", Deps: []string{"zlib"}})
+
+	// the registry isn't affected by changes to the indexed metadata
+	meta.Labels["team"] = "graphics"
+	if p := fixture.Describe("zlib"); p == nil || p.Meta.Version != "1.2.11" || p.Meta.Labels["team"] != "core" {
+		t.Fatalf("Expected zlib to keep its metadata, but got %+v", p)
+	}
+
+	if res := fixture.Annotate("zlib", &Metadata{Version: "1.2.13", License: "Zlib"}); res != OK {
+		t.Errorf("Expected response to be %q, but got %q", OK, res)
+	}
+	p := fixture.Describe("zlib")
+	if p == nil || p.Meta.Version != "1.2.13" || p.Meta.License != "Zlib" || p.Meta.Labels != nil {
+		t.Errorf("Expected zlib metadata to be replaced, but got %+v", p)
+	}
+
+	// an annotation keeps the dependencies and the dependents of the package
+	if res := fixture.Remove("zlib"); res != Fail {
+		t.Errorf("Expected zlib to still be depended on, but got %q", res)
+	}
+
+	if res := fixture.Annotate("openssl", &Metadata{Version: "3.0"}); res != Fail {
+		t.Errorf("Expected response for unindexed package to be %q, but got %q", Fail, res)
+	}
+	if p := fixture.Describe("openssl"); p != nil {
+		t.Errorf("Expected unindexed package to be nil, but got %+v", p)
+	}
+
+	// annotations are journaled, and can be undone
+	changes, err := fixture.Changes(2)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(changes) != 1 || changes[0].Op != "ANNOTATE" || changes[0].Prev.Meta.Version != "1.2.11" {
+		t.Fatalf("Expected an ANNOTATE change, but got %+v", changes)
+	}
+
+	if err := fixture.Undo(1); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if p := fixture.Describe("zlib"); p == nil || p.Meta.Version != "1.2.11" {
+		t.Errorf("Expected zlib metadata to be restored, but got %+v", p)
+	}
+}
+
+func TestAnnotate_Tx(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "zlib"})
+
+	tx := fixture.Begin()
+	if res := tx.Annotate("zlib", &Metadata{Version: "1.2.11"}); res != OK {
+		t.Fatalf("Expected response to be %q, but got %q", OK, res)
+	}
+	if p := tx.Describe("zlib"); p == nil || p.Meta.Version != "1.2.11" {
+		t.Errorf("Expected transaction to see the staged metadata, but got %+v", p)
+	}
+	if p := fixture.Describe("zlib"); p == nil || p.Meta != nil {
+		t.Errorf("Expected staged metadata to be invisible, but got %+v", p)
+	}
+
+	if res := tx.Commit(); res != OK {
+		t.Fatalf("Expected response to be %q, but got %q", OK, res)
+	}
+	if p := fixture.Describe("zlib"); p == nil || p.Meta.Version != "1.2.11" {
+		t.Errorf("Expected committed metadata, but got %+v", p)
+	}
+}
+
+func TestAnnotate_Branch(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "zlib", Meta: &Metadata{Version: "1.2.11"}})
+
+	b, err := fixture.Fork("upgrade")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	b.Annotate("zlib", &Metadata{Version: "1.2.13"})
+
+	d := b.Diff()
+	if len(d.Changed) != 1 || d.Changed[0].Meta.Version != "1.2.13" {
+		t.Errorf("Expected zlib to be changed, but got %+v", d)
+	}
+
+	if res := b.Merge(); res != OK {
+		t.Fatalf("Expected response to be %q, but got %q", OK, res)
+	}
+	if p := fixture.Describe("zlib"); p == nil || p.Meta.Version != "1.2.13" {
+		t.Errorf("Expected merged metadata, but got %+v", p)
+	}
+}
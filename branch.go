@@ -0,0 +1,311 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrBranchExists is an error message indicating a branch of the same name already exists.
+const ErrBranchExists = "Branch already exists"
+
+// Brancher is implemented by indexers whose registry can be forked into named branches.
+type Brancher interface {
+	Fork(name string) (*Branch, error)
+	Branch(name string) *Branch
+	Branches() []string
+}
+
+// Branch is a named, copy-on-write fork of the registry of an InMemoryIndexer.
+// Operations on a branch don't affect the main line of the registry until the branch is merged back.
+// Branch implements the Indexer, Walker and Lister interfaces, and is safe for concurrent use.
+type Branch struct {
+	name string
+	i    *InMemoryIndexer
+
+	// base is the registry as it was when the branch was forked.
+	// Packages are copied into it only when the main line changes them, which holds the locks of their shards exclusively.
+	base *overlay
+
+	// o holds the operations applied to the branch. Both o and base are guarded by m, which is taken after the locks of the indexer and of the shards.
+	// The operations on the branch read the main line through base, so they lock all the shards shared.
+	o    *overlay
+	m    *sync.RWMutex
+	done bool
+}
+
+// Fork creates a new branch called name off the current state of the registry of i.
+// The branch is kept until it is merged or discarded. As the main line copies the packages it changes into every branch, the branches that are no longer needed should be discarded.
+// It returns an error if a branch called name already exists.
+func (i *InMemoryIndexer) Fork(name string) (*Branch, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	if _, exist := i.branches[name]; exist {
+		return nil, fmt.Errorf(ErrBranchExists)
+	}
+
+	base := newOverlay(i.registry)
+	b := &Branch{
+		name: name,
+		i:    i,
+		base: base,
+		o:    newOverlay(base),
+		m:    &sync.RWMutex{},
+	}
+	i.branches[name] = b
+	return b, nil
+}
+
+// Branch returns the branch called name, or nil if there is no such branch.
+func (i *InMemoryIndexer) Branch(name string) *Branch {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	return i.branches[name]
+}
+
+// Branches returns the names of all the branches of i, in alphabetical order.
+func (i *InMemoryIndexer) Branches() []string {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	names := make([]string, 0, len(i.branches))
+	for name := range i.branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Name returns the name of b.
+func (b *Branch) Name() string {
+	return b.name
+}
+
+// Index adds p to b. It has the same semantics as InMemoryIndexer.Index.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Index(p *Pkg) string {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return Error
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.o.index(p)
+}
+
+// Remove removes package name from b. It has the same semantics as InMemoryIndexer.Remove.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Remove(name string) string {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return Error
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.o.remove(name)
+}
+
+// Query checks if name is indexed in b. It has the same semantics as InMemoryIndexer.Query.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Query(name string) string {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return Error
+	}
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	return b.o.query(name)
+}
+
+// Annotate replaces the metadata of package name in b with m. It has the same semantics as InMemoryIndexer.Annotate.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Annotate(name string, m *Metadata) string {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return Error
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	return b.o.annotate(name, m)
+}
+
+// Describe returns package name in b with its metadata, or nil if it isn't indexed in b or if b is already merged or discarded.
+func (b *Branch) Describe(name string) *Pkg {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return nil
+	}
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	p, _ := b.o.lookup(name)
+	return p
+}
+
+// Walk calls fn for every package indexed in b, in alphabetical order, until fn returns false. Like InMemoryIndexer.Walk, fn is called without holding b.
+// fn isn't called if b is already merged or discarded.
+func (b *Branch) Walk(fn func(*Pkg) bool) {
+	for _, p := range b.pkgs() {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// List returns the packages indexed in b that are selected by opts, in alphabetical order. It has the same semantics as InMemoryIndexer.List.
+// It returns an empty page if b is already merged or discarded.
+func (b *Branch) List(opts ListOptions) *Page {
+	page := &Page{}
+	from := opts.from()
+	for _, p := range b.pkgs() {
+		if p.Name < from {
+			continue
+		}
+		if !opts.selects(p.Name) {
+			break
+		}
+		if !opts.Selector.selects(p) {
+			continue
+		}
+
+		if opts.Limit > 0 && len(page.Pkgs) == opts.Limit {
+			page.Next = p.Name
+			break
+		}
+		page.Pkgs = append(page.Pkgs, p)
+	}
+	return page
+}
+
+// pkgs returns the packages indexed in b, in alphabetical order, or nil if b is already merged or discarded.
+// The packages of the branch aren't kept in order, so they are sorted on every call.
+func (b *Branch) pkgs() []*Pkg {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return nil
+	}
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	var pkgs []*Pkg
+	b.o.each(func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+	sort.Sort(byName(pkgs))
+	return pkgs
+}
+
+// Diff compares b against the current state of the main line.
+// Packages that are indexed in b only are reported as added, and those that are indexed in the main line only are reported as removed.
+// It returns nil if b is already merged or discarded.
+func (b *Branch) Diff() *Diff {
+	b.i.rlock()
+	defer b.i.runlock()
+
+	if b.done {
+		return nil
+	}
+
+	b.m.RLock()
+	defer b.m.RUnlock()
+
+	// only the packages changed by either side since the fork can differ
+	d := &Diff{}
+	seen := map[string]bool{}
+	for _, changed := range []map[string]*Pkg{b.o.staged, b.base.staged} {
+		for name := range changed {
+			if !seen[name] {
+				seen[name] = true
+				d.compare(b.i.registry, b.o, name)
+			}
+		}
+	}
+	d.sort()
+	return d
+}
+
+// Merge replays all the operations applied to b, in order, on the main line, and discards b.
+// It returns OK if all the operations could be applied.
+// It returns Fail if the main line has changed since the fork, such that some of the operations now violate the dependencies constraints. In that case, none of the operations are applied and b is kept.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Merge() string {
+	defer b.i.settle()
+	b.i.m.Lock()
+	defer b.i.m.Unlock()
+
+	if b.done {
+		return Error
+	}
+
+	o := b.o.replay(b.i.registry)
+	if o == nil {
+		return Fail
+	}
+
+	b.discard()
+	b.i.apply(o)
+	return OK
+}
+
+// Discard throws b away, without applying any of its operations to the main line.
+// It returns Error if b is already merged or discarded.
+func (b *Branch) Discard() string {
+	b.i.m.Lock()
+	defer b.i.m.Unlock()
+
+	if b.done {
+		return Error
+	}
+
+	b.discard()
+	return OK
+}
+
+// discard detaches b from its indexer.
+// The caller must hold b.i.m exclusively.
+func (b *Branch) discard() {
+	b.done = true
+	b.o, b.base = nil, nil
+	delete(b.i.branches, b.name)
+}
+
+// preserve copies the current version of name from the main line into the base of b, before the main line replaces it with p. A nil p removes name.
+// The caller must hold the locks that InMemoryIndexer.put requires.
+func (b *Branch) preserve(name string, p *Pkg) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	current, _ := b.i.registry.lookup(name)
+	if _, preserved := b.base.staged[name]; !preserved {
+		b.base.staged[name] = current
+	}
+
+	// the base keeps the dependents that the main line drops, and not those it adds
+	b.base.count(current, 1)
+	b.base.count(p, -1)
+}
@@ -0,0 +1,297 @@
+package indexer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFork_Fail_Exists(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	if _, err := fixture.Fork("rehearsal"); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if _, err := fixture.Fork("rehearsal"); err == nil || err.Error() != ErrBranchExists {
+		t.Errorf("Expected error to be %q, but got %v", ErrBranchExists, err)
+	}
+
+	if names := fixture.Branches(); len(names) != 1 || names[0] != "rehearsal" {
+		t.Errorf("Expected branches to be [rehearsal], but got %v", names)
+	}
+}
+
+func TestBranch_Isolation(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, pcre)
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if res := b.Index(nginx); res != OK {
+		t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+	}
+	assertNotExist(fixture, nginx, t)
+
+	// changes to the main line after the fork aren't visible in the branch
+	for _, p := range []*Pkg{zlib} {
+		if res := fixture.Index(p); res != OK {
+			t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+		}
+	}
+	if res := b.Query(zlib.Name); res != Fail {
+		t.Errorf("Expected Query() on branch to return %q, but got %q", Fail, res)
+	}
+
+	// the branch still sees the main line as it was when forked
+	if res := b.Remove(pcre.Name); res != Fail {
+		t.Errorf("Expected Remove() on branch to return %q, but got %q", Fail, res)
+	}
+	if res := fixture.Remove(pcre.Name); res != OK {
+		t.Errorf("Expected Remove() to return %q, but got %q", OK, res)
+	}
+	if res := b.Query(pcre.Name); res != OK {
+		t.Errorf("Expected Query() on branch to return %q, but got %q", OK, res)
+	}
+}
+
+func TestBranch_Diff(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, pcre, zlib, nginx)
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	mysql := &Pkg{Name: "mysql"}
+	nginxZlib := &Pkg{Name: "nginx", Deps: []string{pcre.Name, zlib.Name}}
+	for _, res := range []string{b.Index(mysql), b.Remove(nginx.Name), b.Index(nginxZlib)} {
+		if res != OK {
+			t.Fatalf("Expected branch operations to return %q, but got %q", OK, res)
+		}
+	}
+
+	// removals on the main line are reported as additions of the branch
+	if res := fixture.Remove(zlib.Name); res != OK {
+		t.Fatalf("Expected Remove() to return %q, but got %q", OK, res)
+	}
+
+	d := b.Diff()
+	assertPkgNames(t, "added", d.Added, mysql.Name, zlib.Name)
+	assertPkgNames(t, "removed", d.Removed)
+	assertPkgNames(t, "changed", d.Changed, nginx.Name)
+}
+
+func TestBranch_WalkList(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture, &Pkg{Name: "pcre-8.38"}, &Pkg{Name: "zlib-1.2.8"}, &Pkg{Name: "mysql"})
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, res := range []string{b.Index(&Pkg{Name: "nginx", Deps: []string{"pcre-8.38"}}), b.Remove("mysql"), fixture.Index(&Pkg{Name: "openssl"})} {
+		if res != OK {
+			t.Fatalf("Expected operations to return %q, but got %q", OK, res)
+		}
+	}
+
+	// the packages of the branch are walked in alphabetical order, whatever the main line became
+	var walked []*Pkg
+	b.Walk(func(p *Pkg) bool {
+		walked = append(walked, p)
+		return true
+	})
+	assertPkgNames(t, "walked", walked, "nginx", "pcre-8.38", "zlib-1.2.8")
+
+	page := b.List(ListOptions{Limit: 1, Cursor: "o"})
+	assertPkgNames(t, "listed", page.Pkgs, "pcre-8.38")
+	if page.Next != "zlib-1.2.8" {
+		t.Errorf("Expected next page to start at zlib-1.2.8, but got %q", page.Next)
+	}
+	if d := Compare(b, fixture); len(d.Added) != 2 || len(d.Removed) != 1 {
+		t.Errorf("Expected the branch to be comparable with the main line, but got %+v", d)
+	}
+
+	b.Discard()
+	if page := b.List(ListOptions{}); len(page.Pkgs) != 0 {
+		t.Errorf("Expected discarded branch to list no packages, but got %v", page.Pkgs)
+	}
+}
+
+func TestBranch_Merge(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	for _, p := range []*Pkg{pcre, nginx} {
+		if res := b.Index(p); res != OK {
+			t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+		}
+	}
+
+	if res := b.Merge(); res != OK {
+		t.Fatalf("Expected Merge() to return %q, but got %q", OK, res)
+	}
+	assertExist(fixture, pcre, t)
+	assertExist(fixture, nginx, t)
+
+	if fixture.Branch("rehearsal") != nil {
+		t.Error("Expected branch to be gone after merge")
+	}
+	if res := b.Index(&Pkg{Name: "mysql"}); res != Error {
+		t.Errorf("Expected Index() on merged branch to return %q, but got %q", Error, res)
+	}
+}
+
+func TestBranch_Merge_Fail_Conflict(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, pcre)
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if res := b.Index(nginx); res != OK {
+		t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+	}
+
+	if res := fixture.Remove(pcre.Name); res != OK {
+		t.Fatalf("Expected Remove() to return %q, but got %q", OK, res)
+	}
+
+	if res := b.Merge(); res != Fail {
+		t.Errorf("Expected Merge() to return %q, but got %q", Fail, res)
+	}
+	assertNotExist(fixture, nginx, t)
+
+	// the branch is kept, so that it can be discarded
+	if res := b.Discard(); res != OK {
+		t.Errorf("Expected Discard() to return %q, but got %q", OK, res)
+	}
+	if fixture.Branch("rehearsal") != nil {
+		t.Error("Expected branch to be gone after discard")
+	}
+}
+
+func TestBranch_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// clients concurrently change and read the branch, while others change the main line
+	const workers = 4
+	w := &sync.WaitGroup{}
+	w.Add(workers * 2)
+	for k := 0; k < workers; k++ {
+		go func(name string) {
+			defer w.Done()
+			for n := 0; n < 200; n++ {
+				b.Index(&Pkg{Name: name})
+				b.Query(name)
+				b.Diff()
+				b.Remove(name)
+			}
+		}("branch-" + strconv.Itoa(k))
+
+		go func(name string) {
+			defer w.Done()
+			for n := 0; n < 200; n++ {
+				fixture.Index(&Pkg{Name: name})
+				fixture.Remove(name)
+			}
+		}("main-" + strconv.Itoa(k))
+	}
+	w.Wait()
+
+	if d := b.Diff(); len(d.Added) != 0 || len(d.Removed) != 0 || len(d.Changed) != 0 {
+		t.Errorf("Expected no differences, but got %+v", d)
+	}
+}
+
+func assertPkgNames(t *testing.T, desc string, pkgs []*Pkg, names ...string) {
+	if len(pkgs) != len(names) {
+		t.Errorf("Expected %s packages to be %v, but got %d packages", desc, names, len(pkgs))
+		return
+	}
+
+	for i, p := range pkgs {
+		if p.Name != names[i] {
+			t.Errorf("Expected %s packages to be %v, but got %q at %d", desc, names, p.Name, i)
+		}
+	}
+}
+
+func TestBranch_Merge_Interleaved(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	pcre := &Pkg{Name: "pcre-8.38"}
+	seedRegistry(fixture, pcre)
+
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if res := b.Index(zlib); res != OK {
+		t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+	}
+
+	// the main line indexes and annotates a package of the branch, before the branch is merged
+	fixture.Index(zlib)
+	fixture.Annotate(zlib.Name, &Metadata{Version: "1.2.8"})
+
+	if res := b.Merge(); res != OK {
+		t.Fatalf("Expected Merge() to return %q, but got %q", OK, res)
+	}
+	if p := fixture.Describe(zlib.Name); p == nil || p.Meta == nil || p.Meta.Version != "1.2.8" {
+		t.Errorf("Expected metadata of %q to be kept, but got %+v", zlib.Name, p)
+	}
+
+	// the main line removes a package annotated in the branch, before the branch is merged
+	b, err = fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if res := b.Annotate(pcre.Name, &Metadata{Version: "8.38"}); res != OK {
+		t.Errorf("Expected Annotate() to return %q, but got %q", OK, res)
+	}
+	fixture.Remove(pcre.Name)
+
+	if res := b.Merge(); res != Fail {
+		t.Errorf("Expected Merge() to return %q, but got %q", Fail, res)
+	}
+	assertNotExist(fixture, pcre, t)
+}
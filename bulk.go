@@ -0,0 +1,80 @@
+package indexer
+
+import "sort"
+
+// BulkRemover is implemented by indexers that can remove sets of packages at once.
+type BulkRemover interface {
+	RemoveAll(pattern string, opts SearchOptions) (*RemoveReport, error)
+}
+
+// RemoveReport summarizes the outcome of a RemoveAll.
+type RemoveReport struct {
+	// Removed holds the names of the removed packages, in the order they were removed.
+	Removed []string
+
+	// Kept holds the names of the selected packages that couldn't be removed, because some packages that aren't selected still depend on them, directly or through other kept packages. They are in alphabetical order.
+	Kept []string
+}
+
+// RemoveAll removes the packages indexed in i that Search would return for pattern and opts, regardless of opts.Limit and opts.Cursor.
+// Packages are removed before their dependencies, such that the selected packages that only depend on each other are all removed. The selected packages that other packages still depend on are kept, along with their dependencies.
+// It returns an error if pattern is invalid.
+func (i *InMemoryIndexer) RemoveAll(pattern string, opts SearchOptions) (*RemoveReport, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	opts.Limit, opts.Cursor = 0, ""
+	selected := i.search(re, opts).Pkgs
+	set := make(map[string]*Pkg, len(selected))
+	for _, p := range selected {
+		set[p.Name] = p
+	}
+
+	// count the dependents of every selected package that are selected too
+	internal := map[string]int{}
+	for _, p := range selected {
+		for _, d := range p.Deps {
+			if _, exist := set[d]; exist {
+				internal[d]++
+			}
+		}
+	}
+
+	// packages that unselected packages depend on are kept, and so are their dependencies
+	kept := map[string]bool{}
+	var queue []*Pkg
+	for _, p := range selected {
+		if i.registry.dependents(p.Name) > internal[p.Name] {
+			kept[p.Name] = true
+			queue = append(queue, p)
+		}
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, d := range p.Deps {
+			if dep, exist := set[d]; exist && !kept[d] {
+				kept[d] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	report := &RemoveReport{}
+	for _, p := range reverse(sortByDeps(selected)) {
+		if kept[p.Name] {
+			report.Kept = append(report.Kept, p.Name)
+			continue
+		}
+
+		i.put(p.Name, nil)
+		report.Removed = append(report.Removed, p.Name)
+	}
+	sort.Strings(report.Kept)
+	return report, nil
+}
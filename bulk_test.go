@@ -0,0 +1,50 @@
+package indexer
+
+import "testing"
+
+func TestRemoveAll(t *testing.T) {
+	t.Parallel()
+
+	payments := &Metadata{Labels: map[string]string{"team": "payments"}}
+	fixture := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "openssl", Meta: payments},
+		{Name: "ledger-core", Deps: []string{"openssl"}, Meta: payments},
+		{Name: "ledger-api", Deps: []string{"ledger-core"}, Meta: payments},
+		{Name: "ledger-ui", Deps: []string{"ledger-api"}, Meta: payments},
+		{Name: "fraud-core", Meta: payments},
+		{Name: "fraud-api", Deps: []string{"fraud-core"}, Meta: payments},
+		{Name: "risk", Deps: []string{"fraud-api"}},
+		{Name: "nginx", Deps: []string{"openssl"}},
+	} {
+		if res := fixture.Index(p); res != OK {
+			t.Fatalf("Expected %q to be indexed, but got %q", p.Name, res)
+		}
+	}
+
+	sel, err := ParseSelector("team=payments")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	report, err := fixture.RemoveAll("*", SearchOptions{Selector: sel})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the ledger packages only depend on each other, whereas risk and nginx still depend on the others
+	assertNames(t, "removed", report.Removed, []string{"ledger-ui", "ledger-api", "ledger-core"})
+	assertNames(t, "kept", report.Kept, []string{"fraud-api", "fraud-core", "openssl"})
+	for _, name := range report.Removed {
+		if res := fixture.Query(name); res != Fail {
+			t.Errorf("Expected %q to be removed, but got %q", name, res)
+		}
+	}
+	if count := fixture.count(); count != 5 {
+		t.Errorf("Expected 5 packages to be left, but got %d", count)
+	}
+
+	if _, err := fixture.RemoveAll("lib[", SearchOptions{}); err == nil || err.Error() != ErrInvalidPattern {
+		t.Errorf("Expected error to be %q, but got %v", ErrInvalidPattern, err)
+	}
+}
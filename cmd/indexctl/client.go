@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/ihcsim/indexer"
+)
+
+// client is an indexer.Indexer and an indexer.Walker whose operations are sent to an indexer server, one message at a time.
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// err is the first I/O error of the connection. Once it is set, every operation returns indexer.Error.
+	err error
+}
+
+// dial connects a client to the indexer server listening at addr.
+func dial(addr string) (*client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &client{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Index sends an INDEX message of p to the server, and returns its response.
+func (c *client) Index(p *indexer.Pkg) string {
+	return c.send(indexer.FormatMsg("INDEX", p))
+}
+
+// Remove sends a REMOVE message of name to the server, and returns its response.
+func (c *client) Remove(name string) string {
+	return c.send(indexer.FormatMsg("REMOVE", &indexer.Pkg{Name: name}))
+}
+
+// Query sends a QUERY message of name to the server, and returns its response.
+func (c *client) Query(name string) string {
+	return c.send(indexer.FormatMsg("QUERY", &indexer.Pkg{Name: name}))
+}
+
+// Walk sends a DUMP message to the server, and calls fn for every package of the response, in alphabetical order, until fn returns false. If the server fails to respond with the packages, fn isn't called, and c.err is set.
+func (c *client) Walk(fn func(*indexer.Pkg) bool) {
+	var pkgs []*indexer.Pkg
+	for res := c.send("DUMP||\n"); res != indexer.OK; res, c.err = c.r.ReadString('\n') {
+		if c.err != nil {
+			return
+		}
+
+		p, cmd, err := indexer.ParseMsg(res)
+		if err != nil || cmd != "INDEX" {
+			c.err = fmt.Errorf("unexpected response to DUMP: %q", res)
+			return
+		}
+		pkgs = append(pkgs, p)
+	}
+
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Name < pkgs[j].Name })
+	for _, p := range pkgs {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+func (c *client) send(msg string) string {
+	if c.err != nil {
+		return indexer.Error
+	}
+
+	if _, c.err = c.conn.Write([]byte(msg)); c.err != nil {
+		return indexer.Error
+	}
+
+	var res string
+	if res, c.err = c.r.ReadString('\n'); c.err != nil {
+		return indexer.Error
+	}
+	return res
+}
+
+// Close closes the connection to the server.
+func (c *client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,234 @@
+// Command indexctl loads package metadata into an indexer server, and exports it.
+//
+// Usage:
+//
+//	indexctl [-addr host:port] import [-retries n] <format> [file...]
+//	indexctl [-addr host:port] export <format>
+//	indexctl [-addr host:port] goworkspace <dir>
+//	indexctl formats
+//
+// The import command reads the packages of the files, or of the standard input if there are none, with the importer of format, and indexes them in dependency order, across all the files. The packages that the server doesn't index are sent again up to n times. See indexer.Load.
+// The export command writes the packages of the server to the standard output, with the exporter of format.
+// The goworkspace command indexes the modules of the Go workspace of dir, and their requirements, in dependency order. See indexer.ImportGoWorkspace.
+// The formats command lists the formats of the importers and the exporters.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ihcsim/indexer"
+)
+
+const usage = `usage: indexctl [-addr host:port] import [-retries n] <format> [file...]
+       indexctl [-addr host:port] export <format>
+       indexctl [-addr host:port] goworkspace <dir>
+       indexctl formats`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command of args, and returns its exit code: 0 if all the packages are indexed or exported, 1 if some aren't indexed, and 2 if the command fails.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("indexctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "localhost:8080", "address of the indexer server")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "import":
+		return runImport(*addr, args[1:], stdin, stdout, stderr)
+	case "export":
+		return runExport(*addr, args[1:], stdout, stderr)
+	case "goworkspace":
+		return runGoWorkspace(*addr, args[1:], stdout, stderr)
+	case "formats":
+		if len(args) != 1 {
+			break
+		}
+		importers, exporters := indexer.Formats()
+		fmt.Fprintf(stdout, "import: %s\n", strings.Join(importers, ", "))
+		fmt.Fprintf(stdout, "export: %s\n", strings.Join(exporters, ", "))
+		return 0
+	}
+
+	fmt.Fprintln(stderr, usage)
+	return 2
+}
+
+// runImport indexes the packages of the files of args into the server at addr. See run.
+func runImport(addr string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	retries := flags.Int("retries", 0, "number of times the packages that aren't indexed are sent again")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 || *retries < 0 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+
+	imp, exist := indexer.LookupImporter(flags.Arg(0))
+	if !exist {
+		fmt.Fprintln(stderr, indexer.ErrUnknownImport)
+		return 2
+	}
+
+	c, err := dial(addr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer c.Close()
+
+	pkgs, err := readPkgs(imp, c, flags.Args()[1:], stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	report := indexer.Load(c, pkgs, indexer.LoadOptions{Retries: *retries})
+	return finish(c, report, nil, stdout, stderr)
+}
+
+// readPkgs reads the packages of the files of names with imp, or those of stdin if names is empty.
+func readPkgs(imp indexer.Importer, c *client, names []string, stdin io.Reader) ([]*indexer.Pkg, error) {
+	if len(names) == 0 {
+		return imp.Read(stdin, c)
+	}
+
+	var pkgs []*indexer.Pkg
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		read, err := imp.Read(f, c)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		pkgs = append(pkgs, read...)
+	}
+	return pkgs, nil
+}
+
+// runExport writes the packages of the server at addr to stdout. See run.
+func runExport(addr string, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+
+	exp, exist := indexer.LookupExporter(args[0])
+	if !exist {
+		fmt.Fprintln(stderr, indexer.ErrUnknownFormat)
+		return 2
+	}
+
+	c, err := dial(addr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer c.Close()
+
+	// the exporter is given the packages only once the server has sent all of them, so an incomplete export isn't written
+	var pkgs []*indexer.Pkg
+	c.Walk(func(p *indexer.Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+	if c.err != nil {
+		fmt.Fprintln(stderr, c.err)
+		return 2
+	}
+
+	if err := exp.Write(stdout, walker(pkgs)); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// walker is an indexer.Walker of packages that are in alphabetical order already.
+type walker []*indexer.Pkg
+
+func (w walker) Walk(fn func(*indexer.Pkg) bool) {
+	for _, p := range w {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// runGoWorkspace indexes the modules of the Go workspace of the directory of args into the server at addr. See run.
+func runGoWorkspace(addr string, args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, usage)
+		return 2
+	}
+
+	c, err := dial(addr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer c.Close()
+
+	report, err := indexer.ImportGoWorkspace(args[0], c)
+	return finish(c, report, err, stdout, stderr)
+}
+
+// finish writes report to stdout, or the error of the import, if any, to stderr, and returns the exit code of the import. See run.
+func finish(c *client, report *indexer.ImportReport, err error, stdout, stderr io.Writer) int {
+	if err == nil {
+		err = c.err
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	writeReport(stdout, report)
+	if len(report.Unmet) > 0 || len(report.Failed) > 0 || len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeReport writes a summary of report to w.
+func writeReport(w io.Writer, report *indexer.ImportReport) {
+	fmt.Fprintf(w, "indexed %d packages\n", len(report.Indexed))
+
+	unmet := make([]string, 0, len(report.Unmet))
+	for name := range report.Unmet {
+		unmet = append(unmet, name)
+	}
+	sort.Strings(unmet)
+	for _, name := range unmet {
+		fmt.Fprintf(w, "unmet dependencies of %s: %s\n", name, strings.Join(report.Unmet[name], ", "))
+	}
+
+	if len(report.Failed) > 0 {
+		fmt.Fprintf(w, "failed to index: %s\n", strings.Join(report.Failed, ", "))
+	}
+	if len(report.Errors) > 0 {
+		fmt.Fprintf(w, "errors indexing: %s\n", strings.Join(report.Errors, ", "))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ihcsim/indexer"
+)
+
+// serve applies the messages of the connections of ln to i, like the indexer server does.
+func serve(ln net.Listener, i *indexer.InMemoryIndexer) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+
+				res := indexer.Error
+				if p, cmd, err := indexer.ParseMsg(line); err == nil {
+					switch cmd {
+					case "INDEX":
+						res = i.Index(p)
+					case "REMOVE":
+						res = i.Remove(p.Name)
+					case "QUERY":
+						res = i.Query(p.Name)
+					case "DUMP":
+						var b bytes.Buffer
+						indexer.Dump(&b, i)
+						res = b.String() + indexer.OK
+					}
+				}
+				conn.Write([]byte(res))
+			}
+		}(conn)
+	}
+}
+
+func TestRun_GoWorkspace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer ln.Close()
+
+	fixture := indexer.NewInMemoryIndexer()
+	go serve(ln, fixture)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-addr", ln.Addr().String(), "goworkspace", "../../testdata/gowork"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code to be 0, but got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "indexed 5 packages\n" {
+		t.Errorf("Expected summary of 5 indexed packages, but got %q", stdout.String())
+	}
+	if res := fixture.Query("example.com/api"); res != indexer.OK {
+		t.Errorf("Expected the api module to be indexed, but got %q", res)
+	}
+
+	// the modules are already indexed, so indexing them again is a no-op
+	stdout.Reset()
+	if code := run([]string{"-addr", ln.Addr().String(), "goworkspace", "../../testdata/gowork"}, nil, &stdout, &stderr); code != 0 {
+		t.Errorf("Expected exit code to be 0, but got %d", code)
+	}
+
+	for _, args := range [][]string{{"goworkspace"}, {"import", "../../testdata/gowork"}, {"-addr", ln.Addr().String(), "goworkspace", "../../testdata/none"}} {
+		if code := run(args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit code for %v to be 2, but got %d", args, code)
+		}
+	}
+}
+
+// listen starts a fake server of i, and returns its address.
+func listen(t *testing.T, i *indexer.InMemoryIndexer) (addr string, close func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	go serve(ln, i)
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestRun_Import(t *testing.T) {
+	fixture := indexer.NewInMemoryIndexer()
+	addr, close := listen(t, fixture)
+	defer close()
+
+	// git depends on curl, which comes in the second file
+	stdin := strings.NewReader("INDEX|git|curl\n")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-addr", addr, "import", "index"}, stdin, &stdout, &stderr); code != 1 {
+		t.Fatalf("Expected exit code to be 1, but got %d: %s", code, stderr.String())
+	}
+	if expected := "indexed 0 packages\nunmet dependencies of git: curl\n"; stdout.String() != expected {
+		t.Errorf("Expected summary to be %q, but got %q", expected, stdout.String())
+	}
+
+	dir, err := ioutil.TempDir("", "indexctl")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{"a.txt": "INDEX|git|curl\n", "b.txt": "INDEX|curl|zlib\nINDEX|zlib|\n"}
+	args := []string{"-addr", addr, "import", "-retries", "1", "index"}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		args = append(args, filepath.Join(dir, name))
+	}
+
+	stdout.Reset()
+	if code := run(args, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code to be 0, but got %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "indexed 3 packages\n" {
+		t.Errorf("Expected summary of 3 indexed packages, but got %q", stdout.String())
+	}
+	if res := fixture.Query("git"); res != indexer.OK {
+		t.Errorf("Expected git to be indexed, but got %q", res)
+	}
+
+	for _, args := range [][]string{
+		{"-addr", addr, "import"},
+		{"-addr", addr, "import", "svg"},
+		{"-addr", addr, "import", "index", filepath.Join(dir, "none.txt")},
+		{"-addr", addr, "import", "npm", filepath.Join(dir, "a.txt")},
+	} {
+		if code := run(args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit code for %v to be 2, but got %d", args, code)
+		}
+	}
+}
+
+func TestRun_Export(t *testing.T) {
+	fixture := indexer.NewInMemoryIndexer()
+	fixture.Index(&indexer.Pkg{Name: "zlib"})
+	fixture.Index(&indexer.Pkg{Name: "curl", Deps: []string{"zlib"}})
+	addr, close := listen(t, fixture)
+	defer close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-addr", addr, "export", "mermaid"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code to be 0, but got %d: %s", code, stderr.String())
+	}
+
+	var expected bytes.Buffer
+	indexer.Export(&expected, fixture, indexer.FormatMermaid, indexer.ExportOptions{})
+	if stdout.String() != expected.String() {
+		t.Errorf("Expected export to be %q, but got %q", expected.String(), stdout.String())
+	}
+
+	for _, args := range [][]string{{"-addr", addr, "export"}, {"-addr", addr, "export", "npm"}} {
+		if code := run(args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("Expected exit code for %v to be 2, but got %d", args, code)
+		}
+	}
+}
+
+func TestRun_Formats(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"formats"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code to be 0, but got %d", code)
+	}
+
+	importers, exporters := indexer.Formats()
+	expected := "import: " + strings.Join(importers, ", ") + "\nexport: " + strings.Join(exporters, ", ") + "\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected formats to be %q, but got %q", expected, stdout.String())
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := &indexer.ImportReport{
+		Indexed: []string{"zlib", "openssl"},
+		Unmet:   map[string][]string{"wget": {"libidn2"}, "curl": {"brotli", "nghttp2"}},
+		Failed:  []string{"git"},
+		Errors:  []string{"bad|name"},
+	}
+
+	var buf bytes.Buffer
+	writeReport(&buf, report)
+	expected := "indexed 2 packages\n" +
+		"unmet dependencies of curl: brotli, nghttp2\n" +
+		"unmet dependencies of wget: libidn2\n" +
+		"failed to index: git\n" +
+		"errors indexing: bad|name\n"
+	if buf.String() != expected {
+		t.Errorf("Expected report to be %q, but got %q", expected, buf.String())
+	}
+}
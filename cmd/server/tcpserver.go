@@ -2,16 +2,36 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ihcsim/indexer"
 )
 
+const (
+	// defaultListLimit is the number of packages listed or searched per page, unless the client asks for fewer or more.
+	defaultListLimit = 100
+
+	// maxListLimit is the maximum number of packages listed or searched per page, such that a response stays bounded regardless of the size of the registry.
+	maxListLimit = 1000
+
+	// defaultStatsTop is the number of most depended-on packages reported by STATS, unless the client asks for fewer or more.
+	defaultStatsTop = 10
+
+	// maxWaitTimeout is the longest a WAIT blocks, such that a connection isn't held forever by a package that never lands.
+	maxWaitTimeout = 5 * time.Minute
+)
+
 // TCPServer can handle requests over TCP network.
 type TCPServer struct {
 	ln   net.Listener
@@ -21,6 +41,27 @@ type TCPServer struct {
 	i    indexer.Indexer
 }
 
+// session holds the state of a client connection.
+type session struct {
+	tx     *indexer.Tx
+	branch *indexer.Branch
+
+	// forked holds the branches forked by the client, which are discarded when it goes away, unless they are merged or discarded before.
+	forked []*indexer.Branch
+
+	// watch streams the changes to the registry to the client, once it subscribes.
+	watch *indexer.Watch
+
+	// push writes the message returned by msg to the client between responses, if it is set. msg is called while no request is processed, such that it may read and change the session, and the message is dropped if msg returns an empty string.
+	push func(msg func() string)
+
+	// idle calls fn while messages may be pushed to the client, if it is set. It lets requests that block, like WAIT, not hold up the pushed messages, so fn must neither read nor change the session.
+	idle func(fn func())
+
+	// done is closed once the client goes away.
+	done chan struct{}
+}
+
 // NewTCPServer returns an instance of TCPServer.
 func NewTCPServer() *TCPServer {
 	s := &TCPServer{
@@ -81,20 +122,62 @@ func (s *TCPServer) acceptConn() error {
 	for {
 		conn, err := s.ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			return err
 		}
 
 		go s.handleConn(conn)
 	}
-	s.Close()
-	return nil
 }
 
 func (s *TCPServer) handleConn(conn net.Conn) {
 	defer conn.Close()
 
+	// responses and pushed messages don't interleave, and a message pushed during a request is written after its response, unless the request blocks
+	var wm sync.Mutex
+	sess := &session{done: make(chan struct{})}
+	sess.push = func(msg func() string) {
+		wm.Lock()
+		defer wm.Unlock()
+
+		m := msg()
+		if m == "" {
+			return
+		}
+		if err := s.write(conn, m); err == nil {
+			s.log.Printf("[PUSH] %s (%d bytes): %s", conn.RemoteAddr().String(), len(m), m)
+		}
+	}
+	sess.idle = func(fn func()) {
+		wm.Unlock()
+		defer wm.Lock()
+
+		fn()
+	}
+
+	// discard any uncommitted transaction and the branches forked by the client, and stop streaming changes, when the client goes away
+	defer func() {
+		wm.Lock()
+		defer wm.Unlock()
+
+		close(sess.done)
+		if sess.tx != nil {
+			sess.tx.Abort()
+		}
+		for _, b := range sess.forked {
+			b.Discard()
+		}
+		if sess.watch != nil {
+			sess.watch.Stop()
+		}
+	}()
+
+	// the reader is kept for the lifetime of the connection, as it may have buffered the lines of pipelined requests
+	r := bufio.NewReader(conn)
 	for {
-		line, err := s.read(conn)
+		line, err := s.read(r)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -104,9 +187,11 @@ func (s *TCPServer) handleConn(conn net.Conn) {
 		}
 		s.log.Printf("[RECV] %s (%d bytes): %s", conn.RemoteAddr().String(), len(line), line)
 
-		res := s.process(line)
-
-		if err := s.write(conn, res); err != nil {
+		wm.Lock()
+		res := s.process(line, sess)
+		err = s.write(conn, res)
+		wm.Unlock()
+		if err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -117,8 +202,7 @@ func (s *TCPServer) handleConn(conn net.Conn) {
 	}
 }
 
-func (s *TCPServer) read(conn net.Conn) (string, error) {
-	r := bufio.NewReader(conn)
+func (s *TCPServer) read(r *bufio.Reader) (string, error) {
 	line, err := r.ReadString('\n')
 	if err != nil {
 		return "", err
@@ -126,22 +210,892 @@ func (s *TCPServer) read(conn net.Conn) (string, error) {
 	return line, nil
 }
 
-func (s *TCPServer) process(line string) string {
+func (s *TCPServer) process(line string, sess *session) string {
 	if pkg, cmd, err := indexer.ParseMsg(line); err != nil {
 		s.err <- err
 		return indexer.Error
 	} else {
+		// a subscribed client only receives changes, until it unsubscribes
+		if sess.watch != nil {
+			if cmd != "UNSUBSCRIBE" {
+				return indexer.Error
+			}
+			return s.unsubscribe(sess)
+		}
+
+		// operations within a transaction are staged, and those on a checked out branch don't affect the main line
+		var i indexer.Indexer = s.i
+		switch {
+		case sess.tx != nil:
+			i = sess.tx
+		case sess.branch != nil:
+			i = sess.branch
+		}
+
 		switch cmd {
 		case "INDEX":
-			return s.i.Index(pkg)
+			return i.Index(pkg)
 		case "REMOVE":
-			return s.i.Remove(pkg.Name)
+			if bulk(pkg) {
+				return s.removeAll(i, pkg)
+			}
+			return i.Remove(pkg.Name)
 		case "QUERY":
-			return s.i.Query(pkg.Name)
+			return i.Query(pkg.Name)
+		case "SUBSCRIBE":
+			return s.subscribe(sess, pkg)
+		case "WAIT":
+			return s.wait(i, sess, pkg)
+		case "DEFER":
+			return s.deferIndex(i, sess, pkg)
+		case "PENDING":
+			return s.pending(i, pkg.Name)
+		case "CANCEL":
+			return s.cancel(i, pkg.Name)
+		case "BEGIN":
+			return s.begin(sess)
+		case "COMMIT":
+			return s.commit(sess)
+		case "ABORT":
+			return s.abort(sess)
+		case "BRANCH":
+			return s.fork(sess, pkg.Name)
+		case "BRANCHES":
+			return s.branches()
+		case "CHECKOUT":
+			return s.checkout(sess, pkg.Name)
+		case "DIFF":
+			return s.diff(pkg.Name)
+		case "MERGE":
+			return s.merge(sess, pkg.Name)
+		case "DISCARD":
+			return s.discard(sess, pkg.Name)
+		case "REVISION":
+			return s.revision()
+		case "LOG":
+			return s.changes(pkg.Name)
+		case "UNDO":
+			return s.undo(pkg.Name)
+		case "ROLLBACK":
+			return s.rollback(pkg.Name)
+		case "DUMP":
+			return s.dump()
+		case "LIST":
+			return s.list(i, pkg)
+		case "SEARCH":
+			return s.search(i, pkg)
+		case "FIND":
+			return s.find(i, pkg)
+		case "QUERYX":
+			return s.evaluate(i, pkg)
+		case "STATS":
+			return s.stats(i, pkg)
+		case "EXPORT":
+			return s.export(i, pkg)
+		case "SBOM":
+			return s.sbom(i, pkg)
+		case "ANNOTATE":
+			return s.annotate(i, pkg)
+		case "DESCRIBE":
+			return s.describe(i, pkg.Name)
+		default:
+			return indexer.Error
+		}
+	}
+}
+
+func (s *TCPServer) begin(sess *session) string {
+	t, ok := s.i.(indexer.Transactor)
+	if !ok || sess.tx != nil || sess.branch != nil {
+		return indexer.Error
+	}
+
+	sess.tx = t.Begin()
+	return indexer.OK
+}
+
+func (s *TCPServer) commit(sess *session) string {
+	if sess.tx == nil {
+		return indexer.Error
+	}
+
+	res := sess.tx.Commit()
+	sess.tx = nil
+	return res
+}
+
+func (s *TCPServer) abort(sess *session) string {
+	if sess.tx == nil {
+		return indexer.Error
+	}
+
+	res := sess.tx.Abort()
+	sess.tx = nil
+	return res
+}
+
+// fork forks the branch called name for sess, which owns it until the client goes away.
+func (s *TCPServer) fork(sess *session, name string) string {
+	b, ok := s.i.(indexer.Brancher)
+	if !ok {
+		return indexer.Error
+	}
+
+	branch, err := b.Fork(name)
+	if err != nil {
+		return indexer.Fail
+	}
+	sess.forked = append(sess.forked, branch)
+	return indexer.OK
+}
+
+// branches responds with one BRANCH|<branch>| line per branch, in alphabetical order, followed by OK.
+func (s *TCPServer) branches() string {
+	b, ok := s.i.(indexer.Brancher)
+	if !ok {
+		return indexer.Error
+	}
+
+	var buf bytes.Buffer
+	for _, name := range b.Branches() {
+		buf.WriteString(indexer.FormatMsg("BRANCH", &indexer.Pkg{Name: name}))
+	}
+	return buf.String() + indexer.OK
+}
+
+// checkout directs the subsequent operations of sess to the branch called name.
+// An empty name checks out the main line.
+func (s *TCPServer) checkout(sess *session, name string) string {
+	if sess.tx != nil {
+		return indexer.Error
+	}
+
+	if name == "" {
+		sess.branch = nil
+		return indexer.OK
+	}
+
+	b := s.branch(name)
+	if b == nil {
+		return indexer.Fail
+	}
+
+	sess.branch = b
+	return indexer.OK
+}
+
+// diff responds with one line per package that differs between the branch called name and the main line, followed by OK.
+// Each line is of the form <ADDED|REMOVED|CHANGED>|<package>|<dependencies>.
+func (s *TCPServer) diff(name string) string {
+	b := s.branch(name)
+	if b == nil {
+		return indexer.Fail
+	}
+
+	d := b.Diff()
+	if d == nil {
+		return indexer.Fail
+	}
+
+	var buf bytes.Buffer
+	for _, p := range d.Added {
+		buf.WriteString(indexer.FormatMsg("ADDED", p))
+	}
+	for _, p := range d.Removed {
+		buf.WriteString(indexer.FormatMsg("REMOVED", p))
+	}
+	for _, p := range d.Changed {
+		buf.WriteString(indexer.FormatMsg("CHANGED", p))
+	}
+	return buf.String() + indexer.OK
+}
+
+func (s *TCPServer) merge(sess *session, name string) string {
+	if sess.tx != nil {
+		return indexer.Error
+	}
+
+	b := s.branch(name)
+	if b == nil {
+		return indexer.Fail
+	}
+
+	return s.leave(sess, b, b.Merge())
+}
+
+func (s *TCPServer) discard(sess *session, name string) string {
+	if sess.tx != nil {
+		return indexer.Error
+	}
+
+	b := s.branch(name)
+	if b == nil {
+		return indexer.Fail
+	}
+
+	return s.leave(sess, b, b.Discard())
+}
+
+// leave checks out the main line for sess, if the branch b it is on is gone as indicated by res.
+func (s *TCPServer) leave(sess *session, b *indexer.Branch, res string) string {
+	if res == indexer.OK && sess.branch == b {
+		sess.branch = nil
+	}
+	return res
+}
+
+func (s *TCPServer) branch(name string) *indexer.Branch {
+	b, ok := s.i.(indexer.Brancher)
+	if !ok {
+		return nil
+	}
+	return b.Branch(name)
+}
+
+// revision responds with the current revision of the registry on a line, followed by OK.
+func (s *TCPServer) revision() string {
+	j, ok := s.i.(indexer.Journaler)
+	if !ok {
+		return indexer.Error
+	}
+
+	return strconv.FormatUint(j.Revision(), 10) + "\n" + indexer.OK
+}
+
+// changes responds with the last n changes to the registry, one per line, followed by OK.
+func (s *TCPServer) changes(n string) string {
+	j, ok := s.i.(indexer.Journaler)
+	if !ok {
+		return indexer.Error
+	}
+
+	count, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return indexer.Error
+	}
+
+	since := uint64(0)
+	if rev := j.Revision(); count < rev {
+		since = rev - count
+	}
+
+	changes, err := j.Changes(since)
+	if err != nil {
+		return indexer.Fail
+	}
+
+	var b bytes.Buffer
+	for _, c := range changes {
+		b.WriteString(indexer.FormatChange(c))
+	}
+	return b.String() + indexer.OK
+}
+
+func (s *TCPServer) undo(n string) string {
+	j, ok := s.i.(indexer.Journaler)
+	if !ok {
+		return indexer.Error
+	}
+
+	count, err := strconv.Atoi(n)
+	if err != nil {
+		return indexer.Error
+	}
+
+	return s.rolledBack(j.Undo(count))
+}
+
+func (s *TCPServer) rollback(rev string) string {
+	j, ok := s.i.(indexer.Journaler)
+	if !ok {
+		return indexer.Error
+	}
+
+	r, err := strconv.ParseUint(rev, 10, 64)
+	if err != nil {
+		return indexer.Error
+	}
+
+	return s.rolledBack(j.RollbackTo(r))
+}
+
+// rolledBack responds with the outcome of a rollback. Each conflict is reported on a line of the form CONFLICT|<revision>|<command>|<package>|<reason>, followed by FAIL.
+func (s *TCPServer) rolledBack(err error) string {
+	if err == nil {
+		return indexer.OK
+	}
+
+	var b bytes.Buffer
+	if c, ok := err.(*indexer.ConflictError); ok {
+		for _, conflict := range c.Conflicts {
+			fmt.Fprintf(&b, "CONFLICT|%d|%s|%s|%s\n", conflict.Change.Rev, conflict.Change.Op, conflict.Change.Pkg.Name, conflict.Reason)
+		}
+	}
+	return b.String() + indexer.Fail
+}
+
+// dump responds with an INDEX message per package in dependency order, followed by OK.
+func (s *TCPServer) dump() string {
+	w, ok := s.i.(indexer.Walker)
+	if !ok {
+		return indexer.Error
+	}
+
+	var b bytes.Buffer
+	if err := indexer.Dump(&b, w); err != nil {
+		s.err <- err
+		return indexer.Error
+	}
+	return b.String() + indexer.OK
+}
+
+// list responds with a page of the packages selected by the options of msg. See page.
+func (s *TCPServer) list(i indexer.Indexer, msg *indexer.Pkg) string {
+	l, ok := i.(indexer.Lister)
+	if !ok {
+		return indexer.Error
+	}
+
+	opts, ok := listOptions(msg)
+	if !ok {
+		return indexer.Error
+	}
+
+	return page(l.List(opts))
+}
+
+// listOptions parses the options of a LIST message of the form LIST|<prefix>|<key>=<value>,..., where the keys are start, end, limit, cursor and selector.
+func listOptions(msg *indexer.Pkg) (indexer.ListOptions, bool) {
+	opts := indexer.ListOptions{Prefix: msg.Name, Limit: defaultListLimit}
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return opts, false
+	}
+
+	for _, o := range options {
+		switch o.key {
+		case "start":
+			opts.Start = o.value
+		case "end":
+			opts.End = o.value
+		case "cursor":
+			opts.Cursor = o.value
+		case "limit":
+			opts.Limit, ok = pageLimit(o.value)
+		case "selector":
+			opts.Selector, ok = selector(o.value)
+		default:
+			ok = false
+		}
+		if !ok {
+			return opts, false
+		}
+	}
+	return opts, true
+}
+
+// search responds with a page of the packages whose names match the pattern of msg, and that are selected by its options. See page.
+func (s *TCPServer) search(i indexer.Indexer, msg *indexer.Pkg) string {
+	searcher, ok := i.(indexer.Searcher)
+	if !ok {
+		return indexer.Error
+	}
+
+	opts, ok := searchOptions(msg)
+	if !ok {
+		return indexer.Error
+	}
+
+	p, err := searcher.Search(msg.Name, opts)
+	if err != nil {
+		return indexer.Error
+	}
+	return page(p)
+}
+
+// find responds with a page of the packages whose descriptions have all the words of the query of msg, and that are selected by its options. See page.
+// The options are the same as those of SEARCH.
+func (s *TCPServer) find(i indexer.Indexer, msg *indexer.Pkg) string {
+	searcher, ok := i.(indexer.Searcher)
+	if !ok {
+		return indexer.Error
+	}
+
+	opts, ok := searchOptions(msg)
+	if !ok {
+		return indexer.Error
+	}
+
+	p, err := searcher.SearchText(msg.Name, opts)
+	if err != nil {
+		return indexer.Error
+	}
+	return page(p)
+}
+
+// evaluate responds with a page of the packages that the query expression of msg evaluates to, and that are selected by its options. See page.
+// The options are the same as those of SEARCH.
+func (s *TCPServer) evaluate(i indexer.Indexer, msg *indexer.Pkg) string {
+	evaluator, ok := i.(indexer.Evaluator)
+	if !ok {
+		return indexer.Error
+	}
+
+	opts, ok := searchOptions(msg)
+	if !ok {
+		return indexer.Error
+	}
+
+	p, err := evaluator.Evaluate(msg.Name, opts)
+	if err != nil {
+		return indexer.Error
+	}
+	return page(p)
+}
+
+// stats responds with statistics about the indexed packages, one line per figure:
+//
+//	STAT|<name>|<value>        the packages, edges, roots, leaves, max_depth, avg_depth, max_fan_in and max_fan_out
+//	TOP|<package>|<count>      a most depended-on package, and its number of dependents
+//	FANIN|<range>|<count>      the number of packages whose number of dependents is within range, like 4-7
+//	FANOUT|<range>|<count>     the number of packages whose number of dependencies is within range
+//
+// followed by OK. The only option is top=<n>, the number of TOP lines, which defaults to defaultStatsTop.
+func (s *TCPServer) stats(i indexer.Indexer, msg *indexer.Pkg) string {
+	reporter, ok := i.(indexer.StatsReporter)
+	if !ok {
+		return indexer.Error
+	}
+
+	top := defaultStatsTop
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return indexer.Error
+	}
+	for _, o := range options {
+		n, err := strconv.Atoi(o.value)
+		if o.key != "top" || err != nil || n < 0 {
+			return indexer.Error
+		}
+		top = n
+	}
+
+	st := reporter.Stats(top)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "STAT|packages|%d\nSTAT|edges|%d\nSTAT|roots|%d\nSTAT|leaves|%d\n", st.Packages, st.Edges, st.Roots, st.Leaves)
+	fmt.Fprintf(&b, "STAT|max_depth|%d\nSTAT|avg_depth|%.2f\n", st.MaxDepth, st.AvgDepth)
+	fmt.Fprintf(&b, "STAT|max_fan_in|%d\nSTAT|max_fan_out|%d\n", st.FanIn.Max, st.FanOut.Max)
+	for _, r := range st.MostDepended {
+		fmt.Fprintf(&b, "TOP|%s|%d\n", r.Name, r.Dependents)
+	}
+	distribution(&b, "FANIN", st.FanIn)
+	distribution(&b, "FANOUT", st.FanOut)
+	return b.String() + indexer.OK
+}
+
+// export responds with the dependency graph of the indexed packages, or of the closure of the package of msg, followed by OK. The options are format=dot|graphml|mermaid, which defaults to dot, depth=<n> and highlight=<name>. See indexer.Export.
+// It responds with FAIL if the package of msg isn't indexed.
+func (s *TCPServer) export(i indexer.Indexer, msg *indexer.Pkg) string {
+	w, ok := i.(indexer.Walker)
+	if !ok {
+		return indexer.Error
+	}
+
+	format, opts := indexer.FormatDOT, indexer.ExportOptions{Root: msg.Name}
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return indexer.Error
+	}
+	for _, o := range options {
+		switch o.key {
+		case "format":
+			format = o.value
+		case "depth":
+			depth, err := strconv.Atoi(o.value)
+			if err != nil || depth < 0 {
+				return indexer.Error
+			}
+			opts.Depth = depth
+		case "highlight":
+			opts.Highlight = o.value
+		default:
+			return indexer.Error
+		}
+	}
+
+	var b bytes.Buffer
+	if err := indexer.Export(&b, w, format, opts); err != nil {
+		if err.Error() == indexer.ErrNotIndexed {
+			return indexer.Fail
+		}
+		return indexer.Error
+	}
+	return b.String() + indexer.OK
+}
+
+// sbom responds with a software bill of materials of the indexed packages, or of the closure of the package of msg, followed by OK. The only option is format=cyclonedx|spdx, which defaults to cyclonedx. See indexer.WriteSBOM.
+// It responds with FAIL if the package of msg isn't indexed.
+func (s *TCPServer) sbom(i indexer.Indexer, msg *indexer.Pkg) string {
+	w, ok := i.(indexer.Walker)
+	if !ok {
+		return indexer.Error
+	}
+
+	format := indexer.FormatCycloneDX
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return indexer.Error
+	}
+	for _, o := range options {
+		if o.key != "format" {
+			return indexer.Error
+		}
+		format = o.value
+	}
+
+	var b bytes.Buffer
+	if err := indexer.WriteSBOM(&b, w, format, indexer.SBOMOptions{Root: msg.Name}); err != nil {
+		if err.Error() == indexer.ErrNotIndexed {
+			return indexer.Fail
+		}
+		return indexer.Error
+	}
+	return b.String() + indexer.OK
+}
+
+// distribution writes the non-empty buckets of d to b, one <kind>|<range>|<count> line each.
+func distribution(b *bytes.Buffer, kind string, d indexer.Distribution) {
+	for k, count := range d.Buckets {
+		if count == 0 {
+			continue
+		}
+
+		min, max := indexer.BucketRange(k)
+		rng := strconv.Itoa(min)
+		if max > min {
+			rng += "-" + strconv.Itoa(max)
+		}
+		fmt.Fprintf(b, "%s|%s|%d\n", kind, rng, count)
+	}
+}
+
+// searchOptions parses the options of a SEARCH message of the form SEARCH|<pattern>|<key>=<value>,..., where the keys are depends, dependents, limit, cursor and selector.
+// The only value of dependents is none, which selects the packages that no other package depends on.
+func searchOptions(msg *indexer.Pkg) (indexer.SearchOptions, bool) {
+	opts := indexer.SearchOptions{Limit: defaultListLimit}
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return opts, false
+	}
+
+	for _, o := range options {
+		switch o.key {
+		case "depends":
+			opts.DependsOn = o.value
+		case "dependents":
+			opts.NoDependents, ok = true, o.value == "none"
+		case "cursor":
+			opts.Cursor = o.value
+		case "limit":
+			opts.Limit, ok = pageLimit(o.value)
+		case "selector":
+			opts.Selector, ok = selector(o.value)
+		default:
+			ok = false
+		}
+		if !ok {
+			return opts, false
+		}
+	}
+	return opts, true
+}
+
+// option is a key=value option of a message.
+type option struct {
+	key   string
+	value string
+}
+
+// parseOptions parses the comma-separated options of a message, which are split into fields like dependencies.
+// The value of a selector option may hold commas itself, so the selector must come last, and takes the rest of the options.
+func parseOptions(fields []string) ([]option, bool) {
+	var options []option
+	for k, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return nil, false
+		}
+
+		if kv[0] == "selector" {
+			value := strings.Join(append([]string{kv[1]}, fields[k+1:]...), ",")
+			return append(options, option{key: kv[0], value: value}), true
+		}
+		options = append(options, option{key: kv[0], value: kv[1]})
+	}
+	return options, true
+}
+
+func selector(s string) (indexer.Selector, bool) {
+	sel, err := indexer.ParseSelector(s)
+	return sel, err == nil
+}
+
+// bulk returns true if msg is a bulk REMOVE message of the form REMOVE|<pattern>|<options>, where the options include a selector.
+func bulk(msg *indexer.Pkg) bool {
+	for _, d := range msg.Deps {
+		if strings.HasPrefix(d, "selector=") {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAll removes the packages whose names match the pattern of msg, and that are selected by its options, which are the same as those of SEARCH.
+// It responds with a REMOVED|<package>| line per removed package, in the order they were removed, and a KEPT|<package>| line per selected package that is still depended on.
+// The response ends with OK if all the selected packages are removed, and with FAIL otherwise.
+func (s *TCPServer) removeAll(i indexer.Indexer, msg *indexer.Pkg) string {
+	b, ok := i.(indexer.BulkRemover)
+	if !ok {
+		return indexer.Error
+	}
+
+	opts, ok := searchOptions(msg)
+	if !ok {
+		return indexer.Error
+	}
+
+	report, err := b.RemoveAll(msg.Name, opts)
+	if err != nil {
+		return indexer.Error
+	}
+
+	var buf bytes.Buffer
+	for _, name := range report.Removed {
+		buf.WriteString(indexer.FormatMsg("REMOVED", &indexer.Pkg{Name: name}))
+	}
+	for _, name := range report.Kept {
+		buf.WriteString(indexer.FormatMsg("KEPT", &indexer.Pkg{Name: name}))
+	}
+	if len(report.Kept) > 0 {
+		return buf.String() + indexer.Fail
+	}
+	return buf.String() + indexer.OK
+}
+
+// subscribe puts sess into push mode: every change to the registry whose package matches the pattern of msg is pushed to the client as a <revision>|<INDEX|REMOVE>|<package>|<dependencies> line, like those of LOG. msg is of the form SUBSCRIBE|<pattern>|<options>, where an empty pattern matches all the packages. The options are op=INDEX|REMOVE, since=<revision>, which first pushes the past changes after the revision, and selector=<selector>.
+// It responds with ERROR within a transaction or on a checked out branch, as only the changes to the main line are streamed, and with FAIL if since isn't kept in the journal. If the client falls behind the changes, a LAGGED|<revision>| line with the revision of the last pushed change ends the push mode.
+func (s *TCPServer) subscribe(sess *session, msg *indexer.Pkg) string {
+	watcher, ok := s.i.(indexer.Watcher)
+	if !ok || sess.tx != nil || sess.branch != nil || sess.push == nil {
+		return indexer.Error
+	}
+
+	filter := indexer.WatchFilter{Pattern: msg.Name}
+	options, ok := parseOptions(msg.Deps)
+	if !ok {
+		return indexer.Error
+	}
+	for _, o := range options {
+		switch o.key {
+		case "op":
+			if o.value != "INDEX" && o.value != "REMOVE" {
+				return indexer.Error
+			}
+			filter.Op = o.value
+		case "since":
+			rev, err := strconv.ParseUint(o.value, 10, 64)
+			if err != nil {
+				return indexer.Error
+			}
+			filter.Resume, filter.Since = true, rev
+		case "selector":
+			if filter.Selector, ok = selector(o.value); !ok {
+				return indexer.Error
+			}
 		default:
 			return indexer.Error
 		}
 	}
+
+	w, err := watcher.Watch(filter)
+	if err != nil {
+		if err.Error() == indexer.ErrInvalidPattern {
+			return indexer.Error
+		}
+		return indexer.Fail
+	}
+	sess.watch = w
+
+	go func() {
+		last := filter.Since
+		for c := range w.C {
+			c := c
+			sess.push(func() string {
+				if sess.watch != w {
+					return ""
+				}
+				return indexer.FormatChange(c)
+			})
+			last = c.Rev
+		}
+
+		if w.Err() != nil {
+			sess.push(func() string {
+				if sess.watch != w {
+					return ""
+				}
+				sess.watch = nil
+				return "LAGGED|" + strconv.FormatUint(last, 10) + "|\n"
+			})
+		}
+	}()
+	return indexer.OK
+}
+
+// unsubscribe ends the push mode of sess. No change is pushed after the response.
+func (s *TCPServer) unsubscribe(sess *session) string {
+	sess.watch.Stop()
+	sess.watch = nil
+	return indexer.OK
+}
+
+// wait blocks until the package of msg is indexed, and responds with OK, or with FAIL if the timeout expires first. msg is of the form WAIT|<package>|<timeout>[,removed], where the timeout is a duration like 500ms or 30s, capped at maxWaitTimeout. The removed flag waits until the package isn't indexed instead.
+// Messages are pushed to the client while it blocks.
+func (s *TCPServer) wait(i indexer.Indexer, sess *session, msg *indexer.Pkg) string {
+	w, ok := i.(indexer.Waiter)
+	if !ok || len(msg.Deps) == 0 || len(msg.Deps) > 2 {
+		return indexer.Error
+	}
+
+	timeout, err := time.ParseDuration(msg.Deps[0])
+	if err != nil || timeout < 0 {
+		return indexer.Error
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+
+	opts := indexer.WaitOptions{Timeout: timeout}
+	if len(msg.Deps) == 2 {
+		if msg.Deps[1] != "removed" {
+			return indexer.Error
+		}
+		opts.Removed = true
+	}
+
+	res := indexer.Fail
+	wait := func() {
+		res = w.Wait(msg.Name, opts)
+	}
+	if sess.idle != nil {
+		sess.idle(wait)
+	} else {
+		wait()
+	}
+	return res
+}
+
+// deferIndex indexes msg, or parks it until its dependencies are indexed. Once a parked package is indexed, a LANDED|<package>| message is pushed to the client, or a CANCELLED|<package>| message if it is cancelled first.
+func (s *TCPServer) deferIndex(i indexer.Indexer, sess *session, msg *indexer.Pkg) string {
+	d, ok := i.(indexer.Deferrer)
+	if !ok {
+		return indexer.Error
+	}
+
+	res, landed := d.Defer(msg)
+	if res == indexer.Pending && sess.push != nil {
+		go func() {
+			select {
+			case r := <-landed:
+				cmd := "LANDED"
+				if r != indexer.OK {
+					cmd = "CANCELLED"
+				}
+				sess.push(func() string {
+					return indexer.FormatMsg(cmd, &indexer.Pkg{Name: msg.Name})
+				})
+			case <-sess.done:
+			}
+		}()
+	}
+	return res
+}
+
+// pending responds with a PENDING|<package>|<missing dependencies> line per pending package, or only for the package called name if name is set, followed by OK.
+// It responds with FAIL if name is set, but isn't pending.
+func (s *TCPServer) pending(i indexer.Indexer, name string) string {
+	d, ok := i.(indexer.Deferrer)
+	if !ok {
+		return indexer.Error
+	}
+
+	var b bytes.Buffer
+	for _, p := range d.Pending() {
+		if name == "" || p.Pkg.Name == name {
+			b.WriteString(indexer.FormatMsg("PENDING", &indexer.Pkg{Name: p.Pkg.Name, Deps: p.Missing}))
+		}
+	}
+	if name != "" && b.Len() == 0 {
+		return indexer.Fail
+	}
+	return b.String() + indexer.OK
+}
+
+func (s *TCPServer) cancel(i indexer.Indexer, name string) string {
+	d, ok := i.(indexer.Deferrer)
+	if !ok {
+		return indexer.Error
+	}
+	return d.Cancel(name)
+}
+
+// annotate replaces the metadata of a package with those of msg, which is of the form ANNOTATE|<package>||<metadata>.
+func (s *TCPServer) annotate(i indexer.Indexer, msg *indexer.Pkg) string {
+	a, ok := i.(indexer.Annotator)
+	if !ok {
+		return indexer.Error
+	}
+	return a.Annotate(msg.Name, msg.Meta)
+}
+
+// describe responds with a PKG|<package>|<dependencies>|<metadata> line, followed by OK. The metadata field is left out if the package has none.
+func (s *TCPServer) describe(i indexer.Indexer, name string) string {
+	a, ok := i.(indexer.Annotator)
+	if !ok {
+		return indexer.Error
+	}
+
+	p := a.Describe(name)
+	if p == nil {
+		return indexer.Fail
+	}
+	return indexer.FormatMsg("PKG", p) + indexer.OK
+}
+
+// pageLimit parses the number of packages per page requested by a client, capped at maxListLimit.
+func pageLimit(s string) (int, bool) {
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	return limit, true
+}
+
+// page responds with one PKG|<package>|<dependencies> line per package of p, in alphabetical order.
+// If there are more packages, they are followed by a NEXT|<cursor>| line. The response ends with OK.
+func page(p *indexer.Page) string {
+	var b bytes.Buffer
+	for _, pkg := range p.Pkgs {
+		b.WriteString(indexer.FormatMsg("PKG", pkg))
+	}
+	if p.Next != "" {
+		b.WriteString(indexer.FormatMsg("NEXT", &indexer.Pkg{Name: p.Next}))
+	}
+	return b.String() + indexer.OK
 }
 
 func (s *TCPServer) write(conn net.Conn, res string) error {
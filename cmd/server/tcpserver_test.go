@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -40,7 +43,7 @@ func TestCatchSignals(t *testing.T) {
 
 	// expect error message to be captured
 	errMsg := "test error message"
-	s.err <- fmt.Errorf(errMsg)
+	s.err <- errors.New(errMsg)
 	log := make([]byte, 256)
 	if _, err := r.Read(log); err != nil {
 		t.Fatal(err)
@@ -69,21 +72,27 @@ func TestRead(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// perform read on a new connection
-	expected := "This is a test message\n"
+	// perform reads on a new connection, whose messages are written at once
+	expected := []string{"This is a test message\n", "This is another test message\n"}
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		conn, err := s.ln.Accept()
 		if err != nil {
 			t.Error(err)
+			return
 		}
 
-		actual, err := s.read(conn)
-		if err != nil {
-			t.Error(err)
-		}
+		r := bufio.NewReader(conn)
+		for _, msg := range expected {
+			actual, err := s.read(r)
+			if err != nil {
+				t.Error(err)
+			}
 
-		if expected != actual {
-			t.Errorf("Expected message read to be %q, but got %q", expected, actual)
+			if msg != actual {
+				t.Errorf("Expected message read to be %q, but got %q", msg, actual)
+			}
 		}
 	}()
 
@@ -92,7 +101,8 @@ func TestRead(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer client.Close()
-	client.Write([]byte(expected))
+	client.Write([]byte(strings.Join(expected, "")))
+	<-done
 }
 
 func TestWrite(t *testing.T) {
@@ -108,7 +118,8 @@ func TestWrite(t *testing.T) {
 	go func() {
 		conn, err := s.ln.Accept()
 		if err != nil {
-			t.Fatal(err)
+			t.Error(err)
+			return
 		}
 		if err := s.write(conn, expected); err != nil {
 			t.Error("Unexpected error duing write:", err)
@@ -150,7 +161,618 @@ func TestProcess(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		actual := s.process(test.msg)
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Transaction(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "COMMIT||\n", expected: indexer.Error},
+		{msg: "BEGIN||\n", expected: indexer.OK},
+		{msg: "BEGIN||\n", expected: indexer.Error},
+		{msg: "INDEX|pcre|\n", expected: indexer.OK},
+		{msg: "INDEX|nginx|pcre\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.OK},
+		{msg: "ABORT||\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.Fail},
+		{msg: "BEGIN||\n", expected: indexer.OK},
+		{msg: "INDEX|pcre|\n", expected: indexer.OK},
+		{msg: "INDEX|nginx|pcre\n", expected: indexer.OK},
+		{msg: "COMMIT||\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.OK},
+		{msg: "ABORT||\n", expected: indexer.Error},
+	}
+
+	sess := &session{}
+	for _, test := range tests {
+		actual := s.process(test.msg, sess)
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+
+	// other connections don't see staged operations
+	if res := s.process("BEGIN||\n", sess); res != indexer.OK {
+		t.Fatalf("Expected response to be %q, but got %q", indexer.OK, res)
+	}
+	s.process("INDEX|openssl|\n", sess)
+	if res := s.process("QUERY|openssl|\n", &session{}); res != indexer.Fail {
+		t.Errorf("Expected response to be %q, but got %q", indexer.Fail, res)
+	}
+}
+
+func TestProcess_Branch(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "INDEX|pcre|\n", expected: indexer.OK},
+		{msg: "BRANCH|rehearsal|\n", expected: indexer.OK},
+		{msg: "BRANCH|rehearsal|\n", expected: indexer.Fail},
+		{msg: "CHECKOUT|unknown|\n", expected: indexer.Fail},
+		{msg: "CHECKOUT|rehearsal|\n", expected: indexer.OK},
+		{msg: "INDEX|nginx|pcre\n", expected: indexer.OK},
+		{msg: "REMOVE|pcre|\n", expected: indexer.Fail},
+		{msg: "BEGIN||\n", expected: indexer.Error},
+		{msg: "CHECKOUT||\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.Fail},
+		{msg: "DIFF|rehearsal|\n", expected: "ADDED|nginx|pcre\n" + indexer.OK},
+		{msg: "MERGE|rehearsal|\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.OK},
+		{msg: "DIFF|rehearsal|\n", expected: indexer.Fail},
+		{msg: "BRANCH|scratch|\n", expected: indexer.OK},
+		{msg: "BRANCH|sandbox|\n", expected: indexer.OK},
+		{msg: "BRANCHES||\n", expected: "BRANCH|sandbox|\nBRANCH|scratch|\n" + indexer.OK},
+		{msg: "CHECKOUT|scratch|\n", expected: indexer.OK},
+		{msg: "REMOVE|nginx|\n", expected: indexer.OK},
+		{msg: "LIST||\n", expected: "PKG|pcre|\n" + indexer.OK},
+		{msg: "EXPORT||format=mermaid\n", expected: "flowchart TD\n    n0[\"pcre\"]\n" + indexer.OK},
+		{msg: "DISCARD|scratch|\n", expected: indexer.OK},
+		{msg: "BRANCHES||\n", expected: "BRANCH|sandbox|\n" + indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.OK},
+	}
+
+	sess := &session{}
+	for _, test := range tests {
+		actual := s.process(test.msg, sess)
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Journal(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "INDEX|pcre|\n", expected: indexer.OK},
+		{msg: "INDEX|nginx|pcre\n", expected: indexer.OK},
+		{msg: "REVISION||\n", expected: "2\n" + indexer.OK},
+		{msg: "LOG|1|\n", expected: "2|INDEX|nginx|pcre\n" + indexer.OK},
+		{msg: "UNDO|1|\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.Fail},
+		{msg: "LOG|5|\n", expected: "1|INDEX|pcre|\n2|INDEX|nginx|pcre\n3|REMOVE|nginx|pcre\n" + indexer.OK},
+		{msg: "ROLLBACK|2|\n", expected: indexer.OK},
+		{msg: "QUERY|nginx|\n", expected: indexer.OK},
+		{msg: "ROLLBACK|10|\n", expected: indexer.Fail},
+		{msg: "UNDO|many|\n", expected: indexer.Error},
+	}
+
+	sess := &session{}
+	for _, test := range tests {
+		actual := s.process(test.msg, sess)
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Dump(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|pcre|\n", "INDEX|nginx|pcre\n", "INDEX|ceylon|\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	expected := "INDEX|ceylon|\nINDEX|pcre|\nINDEX|nginx|pcre\n" + indexer.OK
+	if actual := s.process("DUMP||\n", &session{}); actual != expected {
+		t.Errorf("Expected response to be %q, but got %q", expected, actual)
+	}
+}
+
+func TestProcess_List(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|libcurl|\n", "INDEX|libyaml|\n", "INDEX|libxml2|\n", "INDEX|nginx|libcurl\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "LIST||\n", expected: "PKG|libcurl|\nPKG|libxml2|\nPKG|libyaml|\nPKG|nginx|libcurl\n" + indexer.OK},
+		{msg: "LIST|lib|limit=2\n", expected: "PKG|libcurl|\nPKG|libxml2|\nNEXT|libyaml|\n" + indexer.OK},
+		{msg: "LIST|lib|limit=2,cursor=libyaml\n", expected: "PKG|libyaml|\n" + indexer.OK},
+		{msg: "LIST||start=libx,end=nginx\n", expected: "PKG|libxml2|\nPKG|libyaml|\n" + indexer.OK},
+		{msg: "LIST||limit=0\n", expected: indexer.Error},
+		{msg: "LIST||order=desc\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Search(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|openssl|\n", "INDEX|libssl-dev|openssl\n", "INDEX|libcurl|openssl\n", "INDEX|libcurl-dev|libcurl\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "SEARCH|lib*-dev|\n", expected: "PKG|libcurl-dev|libcurl\nPKG|libssl-dev|openssl\n" + indexer.OK},
+		{msg: "SEARCH|/ssl/|limit=1\n", expected: "PKG|libssl-dev|openssl\nNEXT|openssl|\n" + indexer.OK},
+		{msg: "SEARCH|*|depends=openssl,dependents=none\n", expected: "PKG|libssl-dev|openssl\n" + indexer.OK},
+		{msg: "SEARCH|lib[|\n", expected: indexer.Error},
+		{msg: "SEARCH|*|dependents=1\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Query(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|openssl|\n", "INDEX|zlib|\n", "INDEX|nginx|openssl,zlib\n", "INDEX|curl|openssl\n", "INDEX|git|curl,zlib\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "QUERYX|rdeps(openssl) - closure(nginx)|\n", expected: "PKG|curl|openssl\n" + indexer.OK},
+		{msg: "QUERYX|rclosure(zlib) & roots()|limit=1\n", expected: "PKG|git|curl,zlib\nNEXT|nginx|\n" + indexer.OK},
+		{msg: "QUERYX|leaves()|depends=openssl\n", expected: indexer.OK},
+		{msg: "QUERYX|deps(git|\n", expected: indexer.Error},
+		{msg: "QUERYX|parents(git)|\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Stats(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|openssl|\n", "INDEX|zlib|\n", "INDEX|nginx|openssl,zlib\n", "INDEX|curl|openssl\n", "INDEX|git|curl,zlib\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	stats := "STAT|packages|5\nSTAT|edges|5\nSTAT|roots|2\nSTAT|leaves|2\nSTAT|max_depth|2\nSTAT|avg_depth|0.80\nSTAT|max_fan_in|2\nSTAT|max_fan_out|2\n"
+	fanning := "FANIN|0|2\nFANIN|1|1\nFANIN|2-3|2\nFANOUT|0|2\nFANOUT|1|1\nFANOUT|2-3|2\n"
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "STATS||\n", expected: stats + "TOP|openssl|2\nTOP|zlib|2\nTOP|curl|1\n" + fanning + indexer.OK},
+		{msg: "STATS||top=1\n", expected: stats + "TOP|openssl|2\n" + fanning + indexer.OK},
+		{msg: "STATS||top=all\n", expected: indexer.Error},
+		{msg: "STATS||limit=1\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Export(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|openssl|\n", "INDEX|curl|openssl\n", "INDEX|git|curl\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "EXPORT|git|depth=1,highlight=git\n", expected: "digraph packages {\n\t\"curl\";\n\t\"git\" [style=filled, fillcolor=yellow];\n\t\"git\" -> \"curl\";\n}\n" + indexer.OK},
+		{msg: "EXPORT||format=mermaid\n", expected: "flowchart TD\n    n0[\"curl\"]\n    n1[\"git\"]\n    n2[\"openssl\"]\n    n0 --> n2\n    n1 --> n0\n" + indexer.OK},
+		{msg: "EXPORT|nginx|\n", expected: indexer.Fail},
+		{msg: "EXPORT||format=svg\n", expected: indexer.Error},
+		{msg: "EXPORT||depth=-1\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_SBOM(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	for _, msg := range []string{"INDEX|zlib||version=1.2.13&license=Zlib\n", "INDEX|curl|zlib\n", "INDEX|jq|\n"} {
+		if res := s.process(msg, &session{}); res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q", msg, indexer.OK, res)
+		}
+	}
+
+	var tests = []struct {
+		msg        string
+		format     string
+		components int
+	}{
+		{msg: "SBOM|curl|\n", format: "CycloneDX", components: 1},
+		{msg: "SBOM||format=cyclonedx\n", format: "CycloneDX", components: 3},
+		{msg: "SBOM|curl|format=spdx\n", format: "SPDX-2.3", components: 2},
+	}
+
+	for _, test := range tests {
+		res := s.process(test.msg, &session{})
+		if !strings.HasSuffix(res, "}\n"+indexer.OK) {
+			t.Errorf("Expected response for msg %q to be a JSON document, but got %q", test.msg, res)
+			continue
+		}
+
+		var doc struct {
+			BOMFormat   string            `json:"bomFormat"`
+			SPDXVersion string            `json:"spdxVersion"`
+			Components  []json.RawMessage `json:"components"`
+			Packages    []json.RawMessage `json:"packages"`
+		}
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(res, indexer.OK)), &doc); err != nil {
+			t.Errorf("Unexpected error for msg %q: %v", test.msg, err)
+			continue
+		}
+		if format := doc.BOMFormat + doc.SPDXVersion; format != test.format || len(doc.Components)+len(doc.Packages) != test.components {
+			t.Errorf("Expected response for msg %q to be a %s document with %d components, but got %q", test.msg, test.format, test.components, res)
+		}
+	}
+
+	for msg, expected := range map[string]string{"SBOM|wget|\n": indexer.Fail, "SBOM||format=dot\n": indexer.Error, "SBOM||depth=1\n": indexer.Error} {
+		if actual := s.process(msg, &session{}); actual != expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", msg, expected, actual)
+		}
+	}
+}
+
+func TestProcess_Wait(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "INDEX|zlib|\n", expected: indexer.OK},
+		{msg: "WAIT|zlib|0s\n", expected: indexer.OK},
+		{msg: "WAIT|zlib|10ms,removed\n", expected: indexer.Fail},
+		{msg: "WAIT|curl|10ms\n", expected: indexer.Fail},
+		{msg: "WAIT|curl|0s,removed\n", expected: indexer.OK},
+		{msg: "WAIT|curl|\n", expected: indexer.Error},
+		{msg: "WAIT|curl|soon\n", expected: indexer.Error},
+		{msg: "WAIT|curl|-1s\n", expected: indexer.Error},
+		{msg: "WAIT|curl|1s,indexed\n", expected: indexer.Error},
+		{msg: "WAIT|curl|1s,removed,now\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+
+	// another connection indexes the package while WAIT blocks
+	res := make(chan string)
+	go func() {
+		res <- s.process("WAIT|curl|10s\n", &session{})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.process("INDEX|curl|zlib\n", &session{})
+	if actual := <-res; actual != indexer.OK {
+		t.Errorf("Expected response to be %q, but got %q", indexer.OK, actual)
+	}
+}
+
+func TestWait_Push(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+	s.log.SetOutput(ioutil.Discard)
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConn(conn)
+
+	r := bufio.NewReader(client)
+	var tests = []struct {
+		msg      string
+		other    bool
+		expected string
+	}{
+		{msg: "DEFER|curl|openssl\n", expected: indexer.Pending},
+		{msg: "WAIT|zlib|10s\n"},
+		// the messages pushed while WAIT blocks are written before its response
+		{msg: "INDEX|openssl|\n", other: true, expected: "LANDED|curl|\n"},
+		{msg: "INDEX|zlib|\n", other: true, expected: indexer.OK},
+	}
+
+	for _, test := range tests {
+		if test.other {
+			// the change is made by another client, once WAIT blocks
+			time.Sleep(10 * time.Millisecond)
+			s.process(test.msg, &session{})
+		} else if _, err := client.Write([]byte(test.msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		if test.expected == "" {
+			continue
+		}
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		actual, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected %q for msg %q, but got %v", test.expected, test.msg, err)
+		}
+		if actual != test.expected {
+			t.Errorf("Expected %q for msg %q, but got %q", test.expected, test.msg, actual)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+	s.log.SetOutput(ioutil.Discard)
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConn(conn)
+
+	r := bufio.NewReader(client)
+	var tests = []struct {
+		msg      string
+		other    bool
+		expected []string
+	}{
+		{msg: "INDEX|zlib|\n", expected: []string{indexer.OK}},
+		{msg: "BRANCH|rehearsal|\n", expected: []string{indexer.OK}},
+		{msg: "CHECKOUT|rehearsal|\n", expected: []string{indexer.OK}},
+		{msg: "SUBSCRIBE||\n", expected: []string{indexer.Error}},
+		{msg: "CHECKOUT||\n", expected: []string{indexer.OK}},
+		{msg: "SUBSCRIBE||op=ANNOTATE\n", expected: []string{indexer.Error}},
+		{msg: "SUBSCRIBE|lib[|\n", expected: []string{indexer.Error}},
+		{msg: "SUBSCRIBE||since=2\n", expected: []string{indexer.Fail}},
+		{msg: "SUBSCRIBE||since=0\n", expected: []string{indexer.OK, "1|INDEX|zlib|\n"}},
+		{msg: "INDEX|openssl|zlib\n", other: true, expected: []string{"2|INDEX|openssl|zlib\n"}},
+		{msg: "QUERY|zlib|\n", expected: []string{indexer.Error}},
+		{msg: "REMOVE|openssl|\n", other: true, expected: []string{"3|REMOVE|openssl|zlib\n"}},
+		{msg: "UNSUBSCRIBE||\n", expected: []string{indexer.OK}},
+		{msg: "UNSUBSCRIBE||\n", expected: []string{indexer.Error}},
+		{msg: "SUBSCRIBE|open*|op=INDEX\n", expected: []string{indexer.OK}},
+		{msg: "INDEX|curl|zlib\n", other: true},
+		{msg: "INDEX|openssl|zlib\n", other: true, expected: []string{"5|INDEX|openssl|zlib\n"}},
+	}
+
+	for _, test := range tests {
+		if test.other {
+			// the change is made by another client
+			s.process(test.msg, &session{})
+		} else if _, err := client.Write([]byte(test.msg)); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, expected := range test.expected {
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			actual, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Expected %q for msg %q, but got %v", expected, test.msg, err)
+			}
+			if actual != expected {
+				t.Errorf("Expected %q for msg %q, but got %q", expected, test.msg, actual)
+			}
+		}
+	}
+}
+
+func TestProcess_Defer(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	pushed := make(chan string, 2)
+	sess := &session{push: func(msg func() string) { pushed <- msg() }, done: make(chan struct{})}
+	defer close(sess.done)
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "DEFER|curl|openssl,zlib\n", expected: indexer.Pending},
+		{msg: "DEFER|wget|openssl\n", expected: indexer.Pending},
+		{msg: "DEFER|wget|libidn2\n", expected: indexer.Fail},
+		{msg: "INDEX|zlib|\n", expected: indexer.OK},
+		{msg: "PENDING||\n", expected: "PENDING|curl|openssl\nPENDING|wget|openssl\nOK\n"},
+		{msg: "PENDING|wget|\n", expected: "PENDING|wget|openssl\nOK\n"},
+		{msg: "CANCEL|wget|\n", expected: indexer.OK},
+		{msg: "CANCEL|wget|\n", expected: indexer.Fail},
+		{msg: "PENDING|wget|\n", expected: indexer.Fail},
+		{msg: "QUERY|curl|\n", expected: indexer.Fail},
+		{msg: "INDEX|openssl|\n", expected: indexer.OK},
+		{msg: "QUERY|curl|\n", expected: indexer.OK},
+		{msg: "PENDING||\n", expected: indexer.OK},
+		{msg: "DEFER|git|curl\n", expected: indexer.OK},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, sess)
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+
+	// the pushed messages arrive in any order
+	expected := map[string]bool{"CANCELLED|wget|\n": true, "LANDED|curl|\n": true}
+	for k := 0; k < len(expected); k++ {
+		select {
+		case msg := <-pushed:
+			if !expected[msg] {
+				t.Errorf("Unexpected pushed message %q", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected messages to be pushed")
+		}
+	}
+
+	// packages aren't deferred within transactions
+	tx := &session{}
+	s.process("BEGIN||\n", tx)
+	if res := s.process("DEFER|nginx|pcre\n", tx); res != indexer.Error {
+		t.Errorf("Expected response to be %q, but got %q", indexer.Error, res)
+	}
+}
+
+func TestProcess_Metadata(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "INDEX|zlib||description=Compression+library&version=1.2.11\n", expected: indexer.OK},
+		{msg: "INDEX|xz||description=Data+compression\n", expected: indexer.OK},
+		{msg: "DESCRIBE|zlib|\n", expected: "PKG|zlib||description=Compression+library&version=1.2.11\n" + indexer.OK},
+		{msg: "ANNOTATE|zlib||label.team=core&version=1.2.13\n", expected: indexer.OK},
+		{msg: "DESCRIBE|zlib|\n", expected: "PKG|zlib||label.team=core&version=1.2.13\n" + indexer.OK},
+		{msg: "FIND|compression|\n", expected: "PKG|xz||description=Data+compression\n" + indexer.OK},
+		{msg: "ANNOTATE|zlib|\n", expected: indexer.OK},
+		{msg: "DESCRIBE|zlib|\n", expected: "PKG|zlib|\n" + indexer.OK},
+		{msg: "ANNOTATE|openssl||version=3.0\n", expected: indexer.Fail},
+		{msg: "DESCRIBE|openssl|\n", expected: indexer.Fail},
+		{msg: "FIND|-|\n", expected: indexer.Error},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
+		if actual != test.expected {
+			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
+		}
+	}
+}
+
+func TestProcess_Selector(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+
+	var tests = []struct {
+		msg      string
+		expected string
+	}{
+		{msg: "INDEX|openssl||label.team=payments\n", expected: indexer.OK},
+		{msg: "INDEX|ledger|openssl|label.team=payments&label.tier=prod\n", expected: indexer.OK},
+		{msg: "INDEX|ledger-mock||label.team=payments&label.tier=dev\n", expected: indexer.OK},
+		{msg: "INDEX|nginx|openssl\n", expected: indexer.OK},
+		{msg: "LIST||limit=10,selector=team=payments,tier!=dev\n", expected: "PKG|ledger|openssl|label.team=payments&label.tier=prod\nPKG|openssl||label.team=payments\n" + indexer.OK},
+		{msg: "SEARCH|l*|selector=tier in (dev,qa)\n", expected: "PKG|ledger-mock||label.team=payments&label.tier=dev\n" + indexer.OK},
+		{msg: "SEARCH|*|selector=team=\n", expected: indexer.OK},
+		{msg: "LIST||selector=team=pay=ments\n", expected: indexer.Error},
+		{msg: "REMOVE|*|selector=team=payments\n", expected: "REMOVED|ledger-mock|\nREMOVED|ledger|\nKEPT|openssl|\n" + indexer.Fail},
+		{msg: "QUERY|ledger|\n", expected: indexer.Fail},
+		{msg: "REMOVE|nginx|openssl\n", expected: indexer.OK},
+		{msg: "REMOVE|*|dependents=none,selector=team\n", expected: "REMOVED|openssl|\n" + indexer.OK},
+	}
+
+	for _, test := range tests {
+		actual := s.process(test.msg, &session{})
 		if actual != test.expected {
 			t.Errorf("Expected response for msg %q to be %q, but got %q", test.msg, test.expected, actual)
 		}
@@ -182,7 +804,7 @@ func TestProcess_Error(t *testing.T) {
 
 	// call the process method for each test to trigger an error
 	for _, test := range tests {
-		res := s.process(test.msg)
+		res := s.process(test.msg, &session{})
 
 		<-ready
 		if res != indexer.Error {
@@ -240,6 +862,76 @@ func TestHandleConn(t *testing.T) {
 	client.Close()
 }
 
+func TestHandleConn_Pipelined(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+	s.log.SetOutput(ioutil.Discard)
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	go s.handleConn(conn)
+
+	// the requests are written at once, without waiting for their responses
+	go client.Write([]byte("BEGIN||\nINDEX|a|\nINDEX|b|a\nCOMMIT||\nQUERY|b|\n"))
+
+	r := bufio.NewReader(client)
+	for k := 0; k < 5; k++ {
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		actual, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected response %d to be %q, but got %v", k, indexer.OK, err)
+		}
+		if actual != indexer.OK {
+			t.Errorf("Expected response %d to be %q, but got %q", k, indexer.OK, actual)
+		}
+	}
+}
+
+func TestHandleConn_Branches(t *testing.T) {
+	s := NewTCPServer()
+	defer s.Close()
+	s.log.SetOutput(ioutil.Discard)
+
+	// capture errors from server
+	go func() {
+		for range s.err {
+		}
+	}()
+
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.handleConn(conn)
+	}()
+
+	r := bufio.NewReader(client)
+	for _, msg := range []string{"BRANCH|rehearsal|\n", "BRANCH|scratch|\n", "MERGE|scratch|\n"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if res, err := r.ReadString('\n'); err != nil || res != indexer.OK {
+			t.Fatalf("Expected response for msg %q to be %q, but got %q (%v)", msg, indexer.OK, res, err)
+		}
+	}
+
+	// the branches forked by the client are discarded when it goes away
+	client.Close()
+	<-done
+	if b := s.branch("rehearsal"); b != nil {
+		t.Errorf("Expected branch %q to be discarded, but it is still there", b.Name())
+	}
+	if res := s.process("BRANCHES||\n", &session{}); res != indexer.OK {
+		t.Errorf("Expected no branches, but got %q", res)
+	}
+}
+
 func tcpClient(host string) (net.Conn, error) {
 	return net.DialTimeout("tcp", host, time.Millisecond*10)
 }
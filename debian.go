@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterImporter(FormatDebian, ImporterFunc(readDebian))
+}
+
+// ImportDebian reads a Debian Packages index from r, i.e. RFC 822-style stanzas separated by blank lines, and indexes a package per stanza into dst, in dependency order.
+// The dependencies of a package are those of its Depends and Pre-Depends fields, regardless of their version constraints and architecture qualifiers. Of a set of alternatives, like exim4 | postfix, the dependency is the first alternative that is in r or indexed in dst, or else the first package of r that provides one of the alternatives through its Provides field, as virtual packages aren't indexed.
+// The Version, Maintainer, Homepage and Installed-Size fields, and the synopsis of the Description field, are the metadata of the packages. Only the first stanza of every package is imported.
+// It returns an error if r can't be read, or if a stanza is malformed. Otherwise, the report lists the packages whose dependencies are unmet.
+func ImportDebian(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatDebian, LoadOptions{})
+}
+
+// readDebian reads the packages of ImportDebian from r.
+func readDebian(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	stanzas, err := readStanzas(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the names of the packages of r, and the first provider of every virtual package
+	names, providers := map[string]bool{}, map[string]string{}
+	var packages []map[string]string
+	for _, s := range stanzas {
+		name := s["package"]
+		if name == "" {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+		if names[name] {
+			continue
+		}
+
+		names[name] = true
+		packages = append(packages, s)
+		for _, alts := range debianRelations(s["provides"]) {
+			if _, exist := providers[alts[0]]; !exist {
+				providers[alts[0]] = name
+			}
+		}
+	}
+
+	resolve := func(alts []string) string {
+		for _, a := range alts {
+			if names[a] || dst.Query(a) == OK {
+				return a
+			}
+		}
+		for _, a := range alts {
+			if p, exist := providers[a]; exist {
+				return p
+			}
+		}
+		return alts[0]
+	}
+
+	pkgs := make([]*Pkg, 0, len(packages))
+	for _, s := range packages {
+		p := &Pkg{Name: s["package"], Meta: debianMeta(s)}
+		for _, alts := range debianRelations(s["pre-depends"] + "," + s["depends"]) {
+			if d := resolve(alts); d != p.Name {
+				p.Deps = appendDep(p.Deps, d)
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// readStanzas reads the stanzas of r as maps of lowercase field names to values. The continuation lines of a field are joined to its value by newlines.
+func readStanzas(r io.Reader) ([]map[string]string, error) {
+	var stanzas []map[string]string
+	var stanza map[string]string
+	var field string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.TrimSpace(line) == "":
+			stanza = nil
+		case line[0] == ' ' || line[0] == '\t':
+			if stanza == nil {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			stanza[field] += "\n" + strings.TrimSpace(line)
+		case line[0] == '#':
+		default:
+			k := strings.Index(line, ":")
+			if k <= 0 {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			if stanza == nil {
+				stanza = map[string]string{}
+				stanzas = append(stanzas, stanza)
+			}
+			field = strings.ToLower(line[:k])
+			stanza[field] = strings.TrimSpace(line[k+1:])
+		}
+	}
+	return stanzas, scanner.Err()
+}
+
+// debianRelations parses a comma-separated list of package relations, like libc6 (>= 2.34), exim4 | postfix, into the names of the alternatives of every relation.
+func debianRelations(s string) [][]string {
+	var relations [][]string
+	for _, rel := range strings.Split(s, ",") {
+		var alts []string
+		for _, alt := range strings.Split(rel, "|") {
+			alt = strings.TrimSpace(alt)
+			if k := strings.IndexAny(alt, " \t\n([<"); k >= 0 {
+				alt = alt[:k]
+			}
+			if k := strings.Index(alt, ":"); k >= 0 {
+				alt = alt[:k]
+			}
+			if alt != "" {
+				alts = append(alts, alt)
+			}
+		}
+		if len(alts) > 0 {
+			relations = append(relations, alts)
+		}
+	}
+	return relations
+}
+
+func debianMeta(s map[string]string) *Metadata {
+	m := &Metadata{
+		Version:    s["version"],
+		Maintainer: s["maintainer"],
+		Homepage:   s["homepage"],
+	}
+	if desc := s["description"]; desc != "" {
+		m.Description = strings.SplitN(desc, "\n", 2)[0]
+	}
+	if size, err := strconv.ParseInt(s["installed-size"], 10, 64); err == nil {
+		// the installed size is in kibibytes
+		m.Size = size * 1024
+	}
+	return m.clone()
+}
@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportDebian(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/Packages")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+
+	fixture := NewInMemoryIndexer()
+	report, err := ImportDebian(f, fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// exim4-daemon-light depends on the missing libgnutls30, so its dependents fail
+	assertNames(t, "indexed", report.Indexed, []string{"gcc-12-base", "libgcc-s1", "libc6"})
+	assertNames(t, "unmet dependencies of exim4-daemon-light", report.Unmet["exim4-daemon-light"], []string{"libgnutls30"})
+	assertNames(t, "failed", report.Failed, []string{"bsd-mailx", "mailutils"})
+	if len(report.Unmet) != 1 {
+		t.Errorf("Expected only exim4-daemon-light to have unmet dependencies, but got %v", report.Unmet)
+	}
+
+	libc := fixture.Describe("libc6")
+	if libc == nil || libc.Meta.Version != "2.36-9" || libc.Meta.Description != "GNU C Library: Shared libraries" || libc.Meta.Size != 12986*1024 {
+		t.Errorf("Expected metadata of the first libc6 stanza, but got %+v", libc)
+	}
+	assertNames(t, "dependencies of libgcc-s1", fixture.Describe("libgcc-s1").Deps, []string{"gcc-12-base"})
+
+	// once libgnutls30 is indexed, the virtual mail-transport-agent resolves to exim4-daemon-light
+	fixture = NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "libgnutls30"})
+	f.Seek(0, 0)
+	if report, err = ImportDebian(f, fixture); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(report.Unmet) != 0 || len(report.Failed) != 0 {
+		t.Errorf("Expected all the packages to be indexed, but got %+v", report)
+	}
+	assertNames(t, "dependencies of mailutils", fixture.Describe("mailutils").Deps, []string{"libc6", "exim4-daemon-light"})
+	assertNames(t, "dependencies of bsd-mailx", fixture.Describe("bsd-mailx").Deps, []string{"libc6", "exim4-daemon-light"})
+}
+
+func TestImportDebian_Malformed(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"Package libc6\n", " continued\n", "Version: 1.0\n"} {
+		if _, err := ImportDebian(strings.NewReader(data), NewInMemoryIndexer()); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	// ErrNotWalker is an error message indicating an indexer's registry can't be enumerated.
+	ErrNotWalker = "Indexer can't be walked"
+
+	// ErrMergeConflict is an error message indicating a merge was aborted because some packages have different dependencies on both sides.
+	ErrMergeConflict = "Merge conflict"
+)
+
+// MergePolicy decides how Merge resolves the packages that are indexed on both sides, but with different dependencies or metadata.
+type MergePolicy int
+
+const (
+	// KeepOurs keeps the destination's version of conflicting packages.
+	KeepOurs MergePolicy = iota
+
+	// TakeTheirs replaces conflicting packages with the source's version.
+	TakeTheirs
+
+	// AbortOnConflict doesn't merge anything if there are conflicting packages.
+	AbortOnConflict
+
+	// Mirror replaces conflicting packages with the source's version, and also removes the packages that aren't indexed in the source, such that the destination ends up identical to the source.
+	Mirror
+)
+
+// MergeReport summarizes the outcome of a Merge.
+type MergeReport struct {
+	// Indexed holds the names of the packages indexed in the destination.
+	Indexed []string
+
+	// Removed holds the names of the packages removed from the destination, including the dependents of the replaced packages, which are indexed again after them.
+	Removed []string
+
+	// Annotated holds the names of the packages whose metadata were replaced in the destination.
+	Annotated []string
+
+	// Conflicts holds the names of the packages indexed on both sides with different dependencies or metadata.
+	Conflicts []string
+
+	// Failed holds the operations that the destination rejected, because they violate its dependencies constraints. Their revisions are left unset.
+	Failed []Change
+}
+
+// Diff describes the changes that turn one registry into another.
+type Diff struct {
+	// Added holds the packages that are indexed in the other registry only.
+	Added []*Pkg
+
+	// Removed holds the packages that are indexed in the original registry only.
+	Removed []*Pkg
+
+	// Changed holds the packages that are indexed in both registries, but with different dependencies or metadata.
+	// The packages are the versions of the other registry.
+	Changed []*Pkg
+}
+
+// Empty returns true if d doesn't have any changes.
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// compare classifies the change to package name between the from and to views.
+func (d *Diff) compare(from, to view, name string) {
+	f, inFrom := from.lookup(name)
+	t, inTo := to.lookup(name)
+	switch {
+	case inFrom && !inTo:
+		d.Removed = append(d.Removed, f)
+	case !inFrom && inTo:
+		d.Added = append(d.Added, t)
+	case inFrom && inTo && (!sameDeps(f, t) || !sameMeta(f.Meta, t.Meta)):
+		d.Changed = append(d.Changed, t)
+	}
+}
+
+func (d *Diff) sort() {
+	for _, pkgs := range [][]*Pkg{d.Added, d.Removed, d.Changed} {
+		sort.Sort(byName(pkgs))
+	}
+}
+
+// sameDeps returns true if a and b depend on the same packages, regardless of order.
+func sameDeps(a, b *Pkg) bool {
+	if len(a.Deps) != len(b.Deps) {
+		return false
+	}
+
+	deps := map[string]int{}
+	for _, d := range a.Deps {
+		deps[d]++
+	}
+	for _, d := range b.Deps {
+		if deps[d] == 0 {
+			return false
+		}
+		deps[d]--
+	}
+	return true
+}
+
+// Compare returns the changes that turn the registry of from into that of to.
+func Compare(from, to Walker) *Diff {
+	return diff(collect(from), collect(to))
+}
+
+func diff(f, t pkgMap) *Diff {
+	d := &Diff{}
+	for name := range t {
+		d.compare(f, t, name)
+	}
+	for name := range f {
+		if _, exist := t[name]; !exist {
+			d.compare(f, t, name)
+		}
+	}
+	d.sort()
+	return d
+}
+
+// Merge applies the packages of src onto dst, according to policy.
+// Packages are removed from dst before their dependencies, and indexed into dst after their dependencies. The dependents of the replaced packages are removed and indexed again along with them.
+// It returns an error if dst isn't a Walker, or if policy is AbortOnConflict and there are conflicting packages. In the latter case, the report lists the conflicts.
+func Merge(dst Indexer, src Walker, policy MergePolicy) (*MergeReport, error) {
+	w, ok := dst.(Walker)
+	if !ok {
+		return nil, fmt.Errorf(ErrNotWalker)
+	}
+
+	current := collect(w)
+	d := diff(current, collect(src))
+	report := &MergeReport{}
+	for _, p := range d.Changed {
+		report.Conflicts = append(report.Conflicts, p.Name)
+	}
+
+	if policy == AbortOnConflict && len(d.Changed) > 0 {
+		return report, fmt.Errorf(ErrMergeConflict)
+	}
+
+	// conflicting packages are replaced by removing the destination's version first, unless only their metadata differ
+	var removals, indexes, annotations []*Pkg
+	indexes = append(indexes, d.Added...)
+	if policy == TakeTheirs || policy == Mirror {
+		for _, p := range d.Changed {
+			if sameDeps(current[p.Name], p) {
+				annotations = append(annotations, p)
+				continue
+			}
+			removals = append(removals, current[p.Name])
+			indexes = append(indexes, p)
+		}
+	}
+	if policy == Mirror {
+		removals = append(removals, d.Removed...)
+	}
+	removals, indexes, annotations = withDependents(current, collect(src), removals, indexes, annotations)
+
+	kept := map[string]bool{}
+	for _, p := range reverse(sortByDeps(removals)) {
+		if res := dst.Remove(p.Name); res != OK {
+			report.Failed = append(report.Failed, Change{Op: opRemove, Pkg: p})
+			kept[p.Name] = true
+			continue
+		}
+		report.Removed = append(report.Removed, p.Name)
+	}
+
+	for _, p := range sortByDeps(indexes) {
+		// the source's version can't replace a package that couldn't be removed
+		if kept[p.Name] {
+			continue
+		}
+
+		if res := dst.Index(p); res != OK {
+			report.Failed = append(report.Failed, Change{Op: opIndex, Pkg: p})
+			continue
+		}
+		report.Indexed = append(report.Indexed, p.Name)
+	}
+
+	a, _ := dst.(Annotator)
+	for _, p := range annotations {
+		if a == nil || a.Annotate(p.Name, p.Meta) != OK {
+			report.Failed = append(report.Failed, Change{Op: opAnnotate, Pkg: p, Prev: current[p.Name]})
+			continue
+		}
+		report.Annotated = append(report.Annotated, p.Name)
+	}
+	return report, nil
+}
+
+// withDependents adds the dependents of the removed packages of current to the removals, as they can't be removed before them, and to the indexes, with their version of src if any, so that they are indexed again once their dependencies are replaced.
+// The dependents that were only to be annotated are indexed with their metadata instead.
+func withDependents(current, src pkgMap, removals, indexes, annotations []*Pkg) ([]*Pkg, []*Pkg, []*Pkg) {
+	dependents := map[string][]*Pkg{}
+	for _, p := range current {
+		for _, dep := range p.Deps {
+			dependents[dep] = append(dependents[dep], p)
+		}
+	}
+
+	removed := map[string]bool{}
+	for _, p := range removals {
+		removed[p.Name] = true
+	}
+	for k := 0; k < len(removals); k++ {
+		deps := dependents[removals[k].Name]
+		sort.Sort(byName(deps))
+		for _, p := range deps {
+			if removed[p.Name] {
+				continue
+			}
+			removed[p.Name] = true
+			removals = append(removals, p)
+			if q, ok := src[p.Name]; ok {
+				p = q
+			}
+			indexes = append(indexes, p)
+		}
+	}
+
+	annotated := annotations[:0]
+	for _, p := range annotations {
+		if !removed[p.Name] {
+			annotated = append(annotated, p)
+		}
+	}
+	return removals, indexes, annotated
+}
+
+// collect copies the registry of w.
+func collect(w Walker) pkgMap {
+	r := pkgMap{}
+	w.Walk(func(p *Pkg) bool {
+		r[p.Name] = p
+		return true
+	})
+	return r
+}
+
+// byName sorts packages by their names.
+type byName []*Pkg
+
+func (s byName) Len() int           { return len(s) }
+func (s byName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s byName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
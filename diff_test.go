@@ -0,0 +1,197 @@
+package indexer
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	staging, prod := NewInMemoryIndexer(), NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	seedRegistry(staging, pcre, zlib, &Pkg{Name: "nginx", Deps: []string{zlib.Name, pcre.Name}}, &Pkg{Name: "mysql"})
+	seedRegistry(prod, pcre, &Pkg{Name: "nginx", Deps: []string{pcre.Name}}, &Pkg{Name: "haproxy"})
+
+	d := Compare(prod, staging)
+	assertPkgNames(t, "added", d.Added, "mysql", zlib.Name)
+	assertPkgNames(t, "removed", d.Removed, "haproxy")
+	assertPkgNames(t, "changed", d.Changed, "nginx")
+	if len(d.Changed) == 1 && len(d.Changed[0].Deps) != 2 {
+		t.Errorf("Expected changed package to be the staging version, but got %+v", d.Changed[0])
+	}
+
+	if d := Compare(staging, staging); !d.Empty() {
+		t.Errorf("Expected no differences, but got %+v", d)
+	}
+}
+
+func TestSameDeps(t *testing.T) {
+	var tests = []struct {
+		a, b     []string
+		expected bool
+	}{
+		{a: nil, b: []string{}, expected: true},
+		{a: []string{"gmp", "isl"}, b: []string{"isl", "gmp"}, expected: true},
+		{a: []string{"gmp", "isl"}, b: []string{"gmp"}, expected: false},
+		{a: []string{"gmp", "gmp"}, b: []string{"gmp", "isl"}, expected: false},
+	}
+
+	for _, test := range tests {
+		if actual := sameDeps(&Pkg{Deps: test.a}, &Pkg{Deps: test.b}); actual != test.expected {
+			t.Errorf("Expected sameDeps(%v, %v) to be %t", test.a, test.b, test.expected)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	var tests = []struct {
+		policy   MergePolicy
+		indexed  []string
+		removed  []string
+		nginxDep int
+	}{
+		{policy: KeepOurs, indexed: []string{"zlib-1.2.8", "mysql"}, nginxDep: 1},
+		{policy: TakeTheirs, indexed: []string{"zlib-1.2.8", "mysql", "nginx"}, removed: []string{"nginx"}, nginxDep: 2},
+		{policy: Mirror, indexed: []string{"zlib-1.2.8", "mysql", "nginx"}, removed: []string{"haproxy", "nginx"}, nginxDep: 2},
+	}
+
+	for _, test := range tests {
+		staging, prod := NewInMemoryIndexer(), NewInMemoryIndexer()
+		pcre := &Pkg{Name: "pcre-8.38"}
+		zlib := &Pkg{Name: "zlib-1.2.8"}
+		mysql := &Pkg{Name: "mysql", Deps: []string{zlib.Name}}
+		seedRegistry(staging, pcre, zlib, mysql, &Pkg{Name: "nginx", Deps: []string{zlib.Name, pcre.Name}})
+		seedRegistry(prod, pcre, &Pkg{Name: "nginx", Deps: []string{pcre.Name}}, &Pkg{Name: "haproxy"})
+
+		report, err := Merge(prod, staging, test.policy)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		assertNames(t, "indexed", report.Indexed, test.indexed)
+		assertNames(t, "removed", report.Removed, test.removed)
+		assertNames(t, "conflicts", report.Conflicts, []string{"nginx"})
+		if len(report.Failed) > 0 {
+			t.Errorf("Expected no failures, but got %+v", report.Failed)
+		}
+
+		if nginx, _ := prod.registry.lookup("nginx"); len(nginx.Deps) != test.nginxDep {
+			t.Errorf("Expected nginx to have %d dependencies with policy %d, but got %d", test.nginxDep, test.policy, len(nginx.Deps))
+		}
+	}
+}
+
+func TestMerge_Metadata(t *testing.T) {
+	t.Parallel()
+
+	staging, prod := NewInMemoryIndexer(), NewInMemoryIndexer()
+	staging.Index(&Pkg{Name: "zlib", Meta: &Metadata{Version: "1.2.13"}})
+	staging.Index(&Pkg{Name: "libpng", Deps: []string{"zlib"}})
+	prod.Index(&Pkg{Name: "zlib", Meta: &Metadata{Version: "1.2.11"}})
+	prod.Index(&Pkg{Name: "libpng", Deps: []string{"zlib"}})
+
+	// packages whose metadata only differ are annotated in place, as their dependents can't be removed
+	report, err := Merge(prod, staging, TakeTheirs)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	assertNames(t, "conflicts", report.Conflicts, []string{"zlib"})
+	assertNames(t, "annotated", report.Annotated, []string{"zlib"})
+	assertNames(t, "removed", report.Removed, nil)
+	if p := prod.Describe("zlib"); p == nil || p.Meta.Version != "1.2.13" {
+		t.Errorf("Expected zlib metadata to be taken from staging, but got %+v", p)
+	}
+}
+
+func TestMerge_AbortOnConflict(t *testing.T) {
+	t.Parallel()
+
+	staging, prod := NewInMemoryIndexer(), NewInMemoryIndexer()
+	seedRegistry(staging, &Pkg{Name: "pcre-8.38"}, &Pkg{Name: "nginx", Deps: []string{"pcre-8.38"}}, &Pkg{Name: "mysql"})
+	seedRegistry(prod, &Pkg{Name: "nginx"})
+
+	report, err := Merge(prod, staging, AbortOnConflict)
+	if err == nil || err.Error() != ErrMergeConflict {
+		t.Fatalf("Expected error to be %q, but got %v", ErrMergeConflict, err)
+	}
+	assertNames(t, "conflicts", report.Conflicts, []string{"nginx"})
+
+	if count := prod.count(); count != 1 {
+		t.Errorf("Expected destination to be unchanged, but got %d packages", count)
+	}
+}
+
+func TestMerge_Dependents(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []MergePolicy{TakeTheirs, Mirror} {
+		staging, prod := NewInMemoryIndexer(), NewInMemoryIndexer()
+		seedRegistry(staging, &Pkg{Name: "libc"}, &Pkg{Name: "pcre", Deps: []string{"libc"}}, &Pkg{Name: "nginx", Deps: []string{"pcre"}})
+		seedRegistry(prod, &Pkg{Name: "pcre"}, &Pkg{Name: "nginx", Deps: []string{"pcre"}})
+
+		// the unchanged nginx is removed before pcre is replaced, and indexed again after it
+		report, err := Merge(prod, staging, policy)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		assertNames(t, "removed", report.Removed, []string{"nginx", "pcre"})
+		assertNames(t, "indexed", report.Indexed, []string{"libc", "pcre", "nginx"})
+		if len(report.Failed) > 0 {
+			t.Errorf("Expected no failures with policy %d, but got %+v", policy, report.Failed)
+		}
+		if d := Compare(prod, staging); !d.Empty() {
+			t.Errorf("Expected no differences after merging with policy %d, but got %+v", policy, d)
+		}
+	}
+}
+
+func TestMerge_Failed(t *testing.T) {
+	t.Parallel()
+
+	staging := NewInMemoryIndexer()
+	seedRegistry(staging, &Pkg{Name: "pcre-8.38", Deps: []string{"libc"}}, &Pkg{Name: "libc"})
+	prod := &rejectingIndexer{InMemoryIndexer: NewInMemoryIndexer(), name: "pcre-8.38"}
+	seedRegistry(prod.InMemoryIndexer, &Pkg{Name: "pcre-8.38"}, &Pkg{Name: "nginx", Deps: []string{"pcre-8.38"}})
+
+	// pcre can't be replaced because the destination refuses to remove it, so nginx is indexed again against the old one
+	report, err := Merge(prod, staging, TakeTheirs)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	if len(report.Failed) != 1 || report.Failed[0].Op != "REMOVE" || report.Failed[0].Pkg.Name != "pcre-8.38" {
+		t.Errorf("Expected removal of pcre-8.38 to fail, but got %+v", report.Failed)
+	}
+	assertNames(t, "indexed", report.Indexed, []string{"libc", "nginx"})
+	if res := prod.Query("nginx"); res != OK {
+		t.Errorf("Expected nginx to be indexed, but got %q", res)
+	}
+}
+
+// rejectingIndexer is an InMemoryIndexer that refuses to remove a package.
+type rejectingIndexer struct {
+	*InMemoryIndexer
+	name string
+}
+
+func (r *rejectingIndexer) Remove(name string) string {
+	if name == r.name {
+		return Fail
+	}
+	return r.InMemoryIndexer.Remove(name)
+}
+
+func assertNames(t *testing.T, desc string, actual, expected []string) {
+	if len(actual) != len(expected) {
+		t.Errorf("Expected %s to be %v, but got %v", desc, expected, actual)
+		return
+	}
+
+	for i, name := range actual {
+		if name != expected[i] {
+			t.Errorf("Expected %s to be %v, but got %v", desc, expected, actual)
+			return
+		}
+	}
+}
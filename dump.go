@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// FormatIndex is the format of Dump, for the importers and exporters.
+const FormatIndex = "index"
+
+func init() {
+	RegisterImporter(FormatIndex, ImporterFunc(readDump))
+	RegisterExporter(FormatIndex, ExporterFunc(Dump))
+}
+
+// Dump writes every package of src to w as an INDEX message, one per line.
+// Packages come after their dependencies, such that sending the output to an empty indexer rebuilds the same registry.
+func Dump(w io.Writer, src Walker) error {
+	var pkgs []*Pkg
+	src.Walk(func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+
+	b := bufio.NewWriter(w)
+	for _, p := range sortByDeps(pkgs) {
+		if _, err := b.WriteString(FormatMsg(opIndex, p)); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
+
+// readDump reads the packages of the INDEX messages read from r, one per line, like those written by Dump. Blank lines are skipped.
+// The OK line that ends the response to DUMP may come last, such that the response can be imported as it is.
+func readDump(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	var (
+		pkgs  []*Pkg
+		ended bool
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if ended {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+		if line+msgSuffix == OK {
+			ended = true
+			continue
+		}
+
+		p, cmd, err := ParseMsg(line + msgSuffix)
+		if err != nil || cmd != opIndex || p.Name == "" {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+		pkgs = append(pkgs, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
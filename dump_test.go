@@ -0,0 +1,59 @@
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture,
+		&Pkg{Name: "cloog", Deps: []string{"gmp", "isl", "pkg-config"}},
+		&Pkg{Name: "isl", Deps: []string{"gmp"}},
+		&Pkg{Name: "gmp"},
+		&Pkg{Name: "pkg-config"},
+		&Pkg{Name: "ceylon"},
+	)
+
+	var buf bytes.Buffer
+	if err := Dump(&buf, fixture); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	expected := "INDEX|ceylon|\n" +
+		"INDEX|gmp|\n" +
+		"INDEX|isl|gmp\n" +
+		"INDEX|pkg-config|\n" +
+		"INDEX|cloog|gmp,isl,pkg-config\n"
+	if buf.String() != expected {
+		t.Errorf("Expected dump to be %q, but got %q", expected, buf.String())
+	}
+
+	// replaying the dump rebuilds the same registry
+	replica := NewInMemoryIndexer()
+	r := bufio.NewReader(&buf)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+
+		p, cmd, err := ParseMsg(line)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if cmd != "INDEX" {
+			t.Errorf("Expected command to be INDEX, but got %q", cmd)
+		}
+		if res := replica.Index(p); res != OK {
+			t.Errorf("Expected Index() of %q to return %q, but got %q", p.Name, OK, res)
+		}
+	}
+
+	if d := Compare(fixture, replica); !d.Empty() {
+		t.Errorf("Expected replica to be identical, but got %+v", d)
+	}
+}
@@ -0,0 +1,232 @@
+package indexer
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// ErrUnknownFormat is an error message indicating an export format isn't supported.
+	ErrUnknownFormat = "Unknown export format"
+
+	// ErrNotIndexed is an error message indicating a package isn't indexed.
+	ErrNotIndexed = "Package is not indexed"
+)
+
+// The formats of Export.
+const (
+	FormatDOT     = "dot"
+	FormatGraphML = "graphml"
+	FormatMermaid = "mermaid"
+)
+
+func init() {
+	for _, format := range []string{FormatDOT, FormatGraphML, FormatMermaid} {
+		format := format
+		RegisterExporter(format, ExporterFunc(func(w io.Writer, src Walker) error {
+			return Export(w, src, format, ExportOptions{})
+		}))
+	}
+}
+
+// ExportOptions selects the packages rendered by Export.
+type ExportOptions struct {
+	// Root restricts the export to the closure of the package Root, i.e. Root along with all its dependencies. An empty Root exports all the packages.
+	Root string
+
+	// Depth limits the closure of Root to the packages that are at most Depth dependencies away from Root. A zero Depth doesn't limit the closure. Depth is ignored without Root.
+	Depth int
+
+	// Highlight is the name of a package that is rendered distinctly.
+	Highlight string
+}
+
+// Export renders the dependency graph of the packages of src to w, as a Graphviz DOT digraph, a GraphML document or a Mermaid flowchart, according to format. Packages are rendered in the order src walks them, and edges point from packages to their dependencies.
+// It returns an error if format is unknown, or if opts.Root isn't indexed in src.
+func Export(w io.Writer, src Walker, format string, opts ExportOptions) error {
+	var render func(*bufio.Writer, []*Pkg, string) error
+	switch format {
+	case FormatDOT:
+		render = writeDOT
+	case FormatGraphML:
+		render = writeGraphML
+	case FormatMermaid:
+		render = writeMermaid
+	default:
+		return fmt.Errorf(ErrUnknownFormat)
+	}
+
+	var pkgs []*Pkg
+	src.Walk(func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+
+	if opts.Root != "" {
+		var err error
+		if pkgs, err = closure(pkgs, opts.Root, opts.Depth); err != nil {
+			return err
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	if err := render(b, pkgs, opts.Highlight); err != nil {
+		return err
+	}
+	return b.Flush()
+}
+
+// closure returns the packages of pkgs that are at most depth dependencies away from the package root, in the order of pkgs. The dependencies of the packages at the limit are dropped. A zero depth doesn't limit the closure.
+func closure(pkgs []*Pkg, root string, depth int) ([]*Pkg, error) {
+	index := make(map[string]*Pkg, len(pkgs))
+	for _, p := range pkgs {
+		index[p.Name] = p
+	}
+	if _, exist := index[root]; !exist {
+		return nil, fmt.Errorf(ErrNotIndexed)
+	}
+
+	distances := map[string]int{root: 0}
+	queue := []string{root}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if depth > 0 && distances[name] == depth {
+			continue
+		}
+
+		for _, d := range index[name].Deps {
+			if _, seen := distances[d]; !seen {
+				distances[d] = distances[name] + 1
+				queue = append(queue, d)
+			}
+		}
+	}
+
+	var selected []*Pkg
+	for _, p := range pkgs {
+		if _, exist := distances[p.Name]; !exist {
+			continue
+		}
+
+		if depth > 0 && distances[p.Name] == depth {
+			p = &Pkg{Name: p.Name, Meta: p.Meta}
+		}
+		selected = append(selected, p)
+	}
+	return selected, nil
+}
+
+func writeDOT(b *bufio.Writer, pkgs []*Pkg, highlight string) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	fmt.Fprintln(b, "digraph packages {")
+	for _, p := range pkgs {
+		if p.Name == highlight {
+			fmt.Fprintf(b, "\t\"%s\" [style=filled, fillcolor=yellow];\n", quote.Replace(p.Name))
+		} else {
+			fmt.Fprintf(b, "\t\"%s\";\n", quote.Replace(p.Name))
+		}
+	}
+	for _, p := range pkgs {
+		for _, d := range p.Deps {
+			fmt.Fprintf(b, "\t\"%s\" -> \"%s\";\n", quote.Replace(p.Name), quote.Replace(d))
+		}
+	}
+	_, err := fmt.Fprintln(b, "}")
+	return err
+}
+
+// graphML is the root element of a GraphML document.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func writeGraphML(b *bufio.Writer, pkgs []*Pkg, highlight string) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  []graphMLKey{{ID: "highlight", For: "node", AttrName: "highlight", AttrType: "boolean"}},
+		Graph: graphMLGraph{ID: "packages", EdgeDefault: "directed"},
+	}
+	for _, p := range pkgs {
+		node := graphMLNode{ID: p.Name}
+		if p.Name == highlight {
+			node.Data = []graphMLData{{Key: "highlight", Value: "true"}}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node)
+	}
+	for _, p := range pkgs {
+		for _, d := range p.Deps {
+			doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: p.Name, Target: d})
+		}
+	}
+
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(b)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := b.WriteString("\n")
+	return err
+}
+
+// writeMermaid writes a top-down Mermaid flowchart. Since Mermaid node ids can't hold every character of package names, nodes are identified by their position, and labeled with the package names.
+func writeMermaid(b *bufio.Writer, pkgs []*Pkg, highlight string) error {
+	ids := make(map[string]string, len(pkgs))
+	for k, p := range pkgs {
+		ids[p.Name] = fmt.Sprintf("n%d", k)
+	}
+
+	quote := strings.NewReplacer(`"`, "#quot;")
+	fmt.Fprintln(b, "flowchart TD")
+	for _, p := range pkgs {
+		fmt.Fprintf(b, "    %s[\"%s\"]\n", ids[p.Name], quote.Replace(p.Name))
+	}
+	for _, p := range pkgs {
+		for _, d := range p.Deps {
+			fmt.Fprintf(b, "    %s --> %s\n", ids[p.Name], ids[d])
+		}
+	}
+
+	var err error
+	if id, exist := ids[highlight]; exist {
+		fmt.Fprintln(b, "    classDef highlight fill:#ff0,stroke:#333")
+		_, err = fmt.Fprintf(b, "    class %s highlight\n", id)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package indexer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture,
+		&Pkg{Name: "gmp"},
+		&Pkg{Name: "isl", Deps: []string{"gmp"}},
+		&Pkg{Name: "cloog", Deps: []string{"gmp", "isl"}},
+		&Pkg{Name: "ceylon"},
+	)
+
+	var tests = []struct {
+		format   string
+		opts     ExportOptions
+		expected string
+	}{
+		{
+			format: FormatDOT,
+			opts:   ExportOptions{Highlight: "isl"},
+			expected: "digraph packages {\n" +
+				"\t\"ceylon\";\n" +
+				"\t\"cloog\";\n" +
+				"\t\"gmp\";\n" +
+				"\t\"isl\" [style=filled, fillcolor=yellow];\n" +
+				"\t\"cloog\" -> \"gmp\";\n" +
+				"\t\"cloog\" -> \"isl\";\n" +
+				"\t\"isl\" -> \"gmp\";\n" +
+				"}\n",
+		},
+		{
+			format: FormatDOT,
+			opts:   ExportOptions{Root: "cloog", Depth: 1},
+			expected: "digraph packages {\n" +
+				"\t\"cloog\";\n" +
+				"\t\"gmp\";\n" +
+				"\t\"isl\";\n" +
+				"\t\"cloog\" -> \"gmp\";\n" +
+				"\t\"cloog\" -> \"isl\";\n" +
+				"}\n",
+		},
+		{
+			format: FormatGraphML,
+			opts:   ExportOptions{Root: "isl", Highlight: "gmp"},
+			expected: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n" +
+				`  <key id="highlight" for="node" attr.name="highlight" attr.type="boolean"></key>` + "\n" +
+				`  <graph id="packages" edgedefault="directed">` + "\n" +
+				`    <node id="gmp">` + "\n" +
+				`      <data key="highlight">true</data>` + "\n" +
+				`    </node>` + "\n" +
+				`    <node id="isl"></node>` + "\n" +
+				`    <edge source="isl" target="gmp"></edge>` + "\n" +
+				`  </graph>` + "\n" +
+				`</graphml>` + "\n",
+		},
+		{
+			format: FormatMermaid,
+			opts:   ExportOptions{Root: "cloog", Highlight: "cloog"},
+			expected: "flowchart TD\n" +
+				"    n0[\"cloog\"]\n" +
+				"    n1[\"gmp\"]\n" +
+				"    n2[\"isl\"]\n" +
+				"    n0 --> n1\n" +
+				"    n0 --> n2\n" +
+				"    n2 --> n1\n" +
+				"    classDef highlight fill:#ff0,stroke:#333\n" +
+				"    class n0 highlight\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := Export(&buf, fixture, test.format, test.opts); err != nil {
+			t.Errorf("Unexpected error for format %q: %v", test.format, err)
+			continue
+		}
+
+		if buf.String() != test.expected {
+			t.Errorf("Expected %s export with options %+v to be %q, but got %q", test.format, test.opts, test.expected, buf.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, fixture, "svg", ExportOptions{}); err == nil || err.Error() != ErrUnknownFormat {
+		t.Errorf("Expected error to be %q, but got %v", ErrUnknownFormat, err)
+	}
+	if err := Export(&buf, fixture, FormatDOT, ExportOptions{Root: "mpfr"}); err == nil || err.Error() != ErrNotIndexed {
+		t.Errorf("Expected error to be %q, but got %v", ErrNotIndexed, err)
+	}
+}
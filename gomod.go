@@ -0,0 +1,271 @@
+package indexer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterImporter(FormatGoMod, ImporterFunc(readGoMod))
+	RegisterImporter(FormatGoModGraph, ImporterFunc(readGoModGraph))
+}
+
+// ImportGoModGraph reads the output of go mod graph from r, i.e. one requirement per line of the form module@version requirement@version, and indexes a package per module version into dst, in dependency order.
+// The packages are named after the module versions, like golang.org/x/text@v0.3.7, and the main module, which isn't versioned, after its path. The versions are the metadata of the packages. The go and toolchain requirements aren't modules, and are ignored.
+// It returns an error if r can't be read, or if a line is malformed. Otherwise, the report lists the packages whose dependencies are unmet.
+func ImportGoModGraph(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatGoModGraph, LoadOptions{})
+}
+
+// readGoModGraph reads the packages of ImportGoModGraph from r.
+func readGoModGraph(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	var pkgs []*Pkg
+	index := map[string]*Pkg{}
+	node := func(name string) *Pkg {
+		p, exist := index[name]
+		if !exist {
+			p = &Pkg{Name: name}
+			if k := strings.LastIndex(name, "@"); k > 0 {
+				p.Meta = &Metadata{Version: name[k+1:]}
+			}
+			index[name] = p
+			pkgs = append(pkgs, p)
+		}
+		return p
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+
+		if goDirective(fields[1]) {
+			continue
+		}
+		p := node(fields[0])
+		p.Deps = appendDep(p.Deps, node(fields[1]).Name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+// goDirective returns true if the requirement req is on a version of Go or of its toolchain, rather than on a module.
+func goDirective(req string) bool {
+	return strings.HasPrefix(req, "go@") || strings.HasPrefix(req, "toolchain@")
+}
+
+// ImportGoMod reads a go.mod file from r, and indexes a package for its main module into dst, after a package per required module version.
+// The main module is named after its path, and depends on its requirements, which are named after their module versions, like golang.org/x/text@v0.3.7. Replacements apply to the requirements: a requirement replaced by another module version depends on that version, and a requirement replaced by a directory is named after its module path, as it isn't versioned.
+// It returns an error if r can't be read, or if r is malformed. Otherwise, the report lists the packages whose dependencies are unmet.
+func ImportGoMod(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatGoMod, LoadOptions{})
+}
+
+// readGoMod reads the packages of ImportGoMod from r.
+func readGoMod(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	mod, err := parseGoMod(r)
+	if err != nil {
+		return nil, err
+	}
+	return goModPkgs([]*goMod{mod}), nil
+}
+
+// ImportGoWorkspace reads the go.work file of the directory dir, and indexes a package for every module of the workspace into dst, after a package per required module version, in dependency order. If dir has no go.work file, its go.mod file is imported instead.
+// The packages are those of ImportGoMod, except that the requirements on the modules of the workspace are dependencies on their packages, as go builds them from the workspace.
+// It returns an error if a file can't be read, or is malformed. Otherwise, the report lists the packages whose dependencies are unmet.
+func ImportGoWorkspace(dir string, dst Indexer) (*ImportReport, error) {
+	uses := []string{"."}
+	if f, err := os.Open(filepath.Join(dir, "go.work")); err == nil {
+		defer f.Close()
+
+		directives, err := parseGoDirectives(f)
+		if err != nil {
+			return nil, err
+		}
+		uses = nil
+		for _, d := range directives {
+			if d[0] == "use" {
+				if len(d) != 2 {
+					return nil, fmt.Errorf(ErrMalformedImport)
+				}
+				uses = append(uses, d[1])
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var mods []*goMod
+	for _, use := range uses {
+		f, err := os.Open(filepath.Join(dir, use, "go.mod"))
+		if err != nil {
+			return nil, err
+		}
+
+		mod, err := parseGoMod(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+	return Load(dst, goModPkgs(mods), LoadOptions{}), nil
+}
+
+// goMod holds the directives of a go.mod file that relate modules to each other.
+type goMod struct {
+	path     string
+	requires []string
+
+	// replaces maps the replaced modules, either path@version or path for all of their versions, to their replacements, either path@version or a directory.
+	replaces map[string]string
+}
+
+// goModPkgs returns the packages of the main modules of mods, and of their requirements.
+func goModPkgs(mods []*goMod) []*Pkg {
+	workspace := map[string]bool{}
+	for _, mod := range mods {
+		workspace[mod.path] = true
+	}
+
+	var pkgs []*Pkg
+	seen := map[string]bool{}
+	for _, mod := range mods {
+		p := &Pkg{Name: mod.path}
+		for _, req := range mod.requires {
+			path, version := splitModule(req)
+			if workspace[path] {
+				p.Deps = appendDep(p.Deps, path)
+				continue
+			}
+
+			name := req
+			if r, exist := mod.replaces[req]; exist {
+				name = r
+			} else if r, exist := mod.replaces[path]; exist {
+				name = r
+			}
+			if goModDir(name) {
+				name, version = path, ""
+			} else {
+				_, version = splitModule(name)
+			}
+
+			p.Deps = appendDep(p.Deps, name)
+			if !seen[name] {
+				seen[name] = true
+				pkgs = append(pkgs, &Pkg{Name: name, Meta: (&Metadata{Version: version}).clone()})
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// splitModule splits a module version like golang.org/x/text@v0.3.7 into its path and version.
+func splitModule(mod string) (path, version string) {
+	if k := strings.LastIndex(mod, "@"); k > 0 {
+		return mod[:k], mod[k+1:]
+	}
+	return mod, ""
+}
+
+// goModDir returns true if the replacement r of a module is a directory, rather than a module version.
+func goModDir(r string) bool {
+	return strings.HasPrefix(r, "./") || strings.HasPrefix(r, "../") || filepath.IsAbs(r)
+}
+
+// parseGoMod parses the module, require and replace directives of the go.mod file read from r.
+func parseGoMod(r io.Reader) (*goMod, error) {
+	directives, err := parseGoDirectives(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mod := &goMod{replaces: map[string]string{}}
+	for _, d := range directives {
+		switch d[0] {
+		case "module":
+			if len(d) != 2 {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			mod.path = d[1]
+		case "require":
+			if len(d) != 3 {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			mod.requires = append(mod.requires, d[1]+"@"+d[2])
+		case "replace":
+			// old [version] => new [version]
+			k := 0
+			for k < len(d) && d[k] != "=>" {
+				k++
+			}
+			if k < 2 || k > 3 || len(d)-k < 2 || len(d)-k > 3 {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			old, replacement := strings.Join(d[1:k], "@"), strings.Join(d[k+1:], "@")
+			mod.replaces[old] = replacement
+		}
+	}
+
+	if mod.path == "" {
+		return nil, fmt.Errorf(ErrMalformedImport)
+	}
+	return mod, nil
+}
+
+// parseGoDirectives parses the directives of a go.mod or go.work file read from r, into their verbs followed by their arguments. The directives of a block, like require ( ... ), are returned one by one, with the verb of the block.
+func parseGoDirectives(r io.Reader) ([][]string, error) {
+	var directives [][]string
+	var block string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if k := strings.Index(line, "//"); k >= 0 {
+			line = line[:k]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		for k, f := range fields {
+			if s, err := strconv.Unquote(f); err == nil {
+				fields[k] = s
+			}
+		}
+
+		switch {
+		case block != "" && len(fields) == 1 && fields[0] == ")":
+			block = ""
+		case block != "":
+			directives = append(directives, append([]string{block}, fields...))
+		case len(fields) == 2 && fields[1] == "(":
+			block = fields[0]
+		default:
+			directives = append(directives, fields)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if block != "" {
+		return nil, fmt.Errorf(ErrMalformedImport)
+	}
+	return directives, nil
+}
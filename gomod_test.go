@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImportGoModGraph(t *testing.T) {
+	t.Parallel()
+
+	graph := `example.com/api github.com/gorilla/mux@v1.8.0
+example.com/api golang.org/x/net@v0.17.0
+example.com/api go@1.21
+golang.org/x/net@v0.17.0 golang.org/x/text@v0.13.0
+golang.org/x/net@v0.17.0 golang.org/x/sys@v0.13.0
+golang.org/x/text@v0.13.0 golang.org/x/tools@v0.6.0
+golang.org/x/tools@v0.6.0 golang.org/x/text@v0.3.7
+golang.org/x/net@v0.17.0 toolchain@go1.21.0
+`
+
+	fixture := NewInMemoryIndexer()
+	report, err := ImportGoModGraph(strings.NewReader(graph), fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	assertNames(t, "indexed", report.Indexed, []string{
+		"github.com/gorilla/mux@v1.8.0",
+		"golang.org/x/text@v0.3.7",
+		"golang.org/x/tools@v0.6.0",
+		"golang.org/x/text@v0.13.0",
+		"golang.org/x/sys@v0.13.0",
+		"golang.org/x/net@v0.17.0",
+		"example.com/api",
+	})
+	assertNames(t, "dependencies of x/net", fixture.Describe("golang.org/x/net@v0.17.0").Deps, []string{"golang.org/x/text@v0.13.0", "golang.org/x/sys@v0.13.0"})
+	if p := fixture.Describe("golang.org/x/text@v0.3.7"); p == nil || p.Meta.Version != "v0.3.7" {
+		t.Errorf("Expected version of x/text to be v0.3.7, but got %+v", p)
+	}
+	if p := fixture.Describe("example.com/api"); p == nil || p.Meta != nil {
+		t.Errorf("Expected main module to have no version, but got %+v", p)
+	}
+
+	if _, err := ImportGoModGraph(strings.NewReader("example.com/api\n"), fixture); err == nil || err.Error() != ErrMalformedImport {
+		t.Errorf("Expected error to be %q, but got %v", ErrMalformedImport, err)
+	}
+}
+
+func TestImportGoMod(t *testing.T) {
+	t.Parallel()
+
+	gomod := `module "example.com/api"
+
+go 1.21
+
+require github.com/gorilla/mux v1.8.0
+require (
+	golang.org/x/text v0.3.7 // indirect
+	github.com/pkg/errors v0.9.1
+	example.com/internal v0.0.0-00010101000000-000000000000
+)
+
+replace (
+	golang.org/x/text v0.3.7 => golang.org/x/text v0.14.0
+	example.com/internal => ../internal
+)
+
+retract [v1.0.0, v1.0.5]
+`
+
+	fixture := NewInMemoryIndexer()
+	report, err := ImportGoMod(strings.NewReader(gomod), fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	assertNames(t, "indexed", report.Indexed, []string{"github.com/gorilla/mux@v1.8.0", "golang.org/x/text@v0.14.0", "github.com/pkg/errors@v0.9.1", "example.com/internal", "example.com/api"})
+	assertNames(t, "dependencies of the main module", fixture.Describe("example.com/api").Deps, []string{"github.com/gorilla/mux@v1.8.0", "golang.org/x/text@v0.14.0", "github.com/pkg/errors@v0.9.1", "example.com/internal"})
+
+	for _, data := range []string{"go 1.21\n", "module example.com/api\nrequire (\n", "module example.com/api\nrequire github.com/pkg/errors\n", "module example.com/api\nreplace a => \n"} {
+		if _, err := ImportGoMod(strings.NewReader(data), NewInMemoryIndexer()); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
+
+func TestImportGoWorkspace(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	report, err := ImportGoWorkspace("testdata/gowork", fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the api module depends on the lib module of the workspace, rather than on its v0.1.0 version
+	assertNames(t, "indexed", report.Indexed, []string{"github.com/gorilla/mux@v1.8.0", "golang.org/x/text@v0.14.0", "golang.org/x/text@v0.3.7", "example.com/lib", "example.com/api"})
+	assertNames(t, "dependencies of the api module", fixture.Describe("example.com/api").Deps, []string{"example.com/lib", "github.com/gorilla/mux@v1.8.0", "golang.org/x/text@v0.14.0"})
+
+	// a directory without go.work is a workspace of its single module
+	fixture = NewInMemoryIndexer()
+	if report, err = ImportGoWorkspace("testdata/gowork/lib", fixture); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	assertNames(t, "indexed", report.Indexed, []string{"golang.org/x/text@v0.3.7", "example.com/lib"})
+
+	if _, err := ImportGoWorkspace("testdata", NewInMemoryIndexer()); err == nil {
+		t.Error("Expected an error for a directory without go.mod")
+	}
+}
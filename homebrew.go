@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+)
+
+// brewInfo is the shape of the output of brew info --json=v2.
+type brewInfo struct {
+	Formulae []brewFormula `json:"formulae"`
+}
+
+type brewFormula struct {
+	Name     string   `json:"name"`
+	FullName string   `json:"full_name"`
+	Aliases  []string `json:"aliases"`
+	Desc     string   `json:"desc"`
+	License  string   `json:"license"`
+	Homepage string   `json:"homepage"`
+	Versions struct {
+		Stable string `json:"stable"`
+	} `json:"versions"`
+
+	Dependencies         []string `json:"dependencies"`
+	BuildDependencies    []string `json:"build_dependencies"`
+	OptionalDependencies []string `json:"optional_dependencies"`
+}
+
+func init() {
+	RegisterImporter(FormatHomebrew, ImporterFunc(readHomebrew))
+}
+
+// ImportHomebrew reads Homebrew formulae from r, in the JSON shape of brew info --json=v2, and indexes a package per formula into dst, in dependency order. Casks are ignored.
+// The dependencies of a package are those of the dependencies, build_dependencies and optional_dependencies of its formula. Dependencies on the full names or the aliases of the formulae of r are dependencies on their names.
+// The stable version, the license, the homepage and the description of the formulae are the metadata of the packages.
+// It returns an error if r can't be read, or isn't made of formulae. Otherwise, the report lists the packages whose dependencies are unmet.
+func ImportHomebrew(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatHomebrew, LoadOptions{})
+}
+
+// readHomebrew reads the packages of ImportHomebrew from r.
+func readHomebrew(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	var info brewInfo
+	if err := decodeJSON(r, &info); err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for _, f := range info.Formulae {
+		if f.Name == "" {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+
+		names[f.Name] = f.Name
+	}
+	for _, f := range info.Formulae {
+		for _, alias := range append([]string{f.FullName}, f.Aliases...) {
+			if _, exist := names[alias]; alias != "" && !exist {
+				names[alias] = f.Name
+			}
+		}
+	}
+
+	pkgs := make([]*Pkg, 0, len(info.Formulae))
+	for _, f := range info.Formulae {
+		p := &Pkg{
+			Name: f.Name,
+			Meta: (&Metadata{Description: f.Desc, Version: f.Versions.Stable, License: f.License, Homepage: f.Homepage}).clone(),
+		}
+		for _, deps := range [][]string{f.Dependencies, f.BuildDependencies, f.OptionalDependencies} {
+			for _, d := range deps {
+				if name, exist := names[d]; exist {
+					d = name
+				}
+				p.Deps = appendDep(p.Deps, d)
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,61 @@
+package indexer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportHomebrew(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/brew-info.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "libunistring"})
+	report, err := ImportHomebrew(f, fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// formulae are indexed after their dependencies, and curl depends on a formula of another tap
+	assertNames(t, "indexed", report.Indexed, []string{"pkg-config", "libidn2", "ca-certificates", "openssl@3", "wget"})
+	assertNames(t, "unmet dependencies of curl", report.Unmet["curl"], []string{"hashicorp/tap/terraform"})
+	assertNames(t, "failed", report.Failed, nil)
+
+	wget := fixture.Describe("wget")
+	if wget == nil || wget.Meta.Version != "1.21.4" || wget.Meta.License != "GPL-3.0-or-later" || wget.Meta.Description != "Internet file retriever" {
+		t.Errorf("Expected metadata of wget, but got %+v", wget)
+	}
+	assertNames(t, "dependencies of wget", wget.Deps, []string{"libidn2", "openssl@3", "pkg-config"})
+}
+
+func TestImportHomebrew_Aliases(t *testing.T) {
+	t.Parallel()
+
+	data := `{"formulae": [
+		{"name": "curl", "dependencies": ["openssl"], "build_dependencies": ["homebrew/core/pkg-config"]},
+		{"name": "openssl@3", "aliases": ["openssl"]},
+		{"name": "pkg-config", "full_name": "homebrew/core/pkg-config"}
+	]}`
+
+	fixture := NewInMemoryIndexer()
+	if _, err := ImportHomebrew(strings.NewReader(data), fixture); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	assertNames(t, "dependencies of curl", fixture.Describe("curl").Deps, []string{"openssl@3", "pkg-config"})
+}
+
+func TestImportHomebrew_Malformed(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"", `{"formulae": [{"name": "wget"}`, `{"formulae": {"name": "wget"}}`, `{"formulae": [{"desc": "Internet file retriever"}]}`} {
+		if _, err := ImportHomebrew(strings.NewReader(data), NewInMemoryIndexer()); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	// ErrMalformedImport is an error message indicating the data read by an importer is malformed.
+	ErrMalformedImport = "Malformed import data"
+
+	// ErrUnknownImport is an error message indicating no importer is registered for a format.
+	ErrUnknownImport = "Unknown import format"
+)
+
+// The formats of the importers of this package, besides FormatIndex.
+const (
+	FormatDebian     = "debian"
+	FormatHomebrew   = "homebrew"
+	FormatGoMod      = "gomod"
+	FormatGoModGraph = "gomodgraph"
+	FormatNpm        = "npm"
+	FormatRPM        = "rpm"
+)
+
+// Importer reads the packages of a package manager's metadata format.
+type Importer interface {
+	// Read returns the packages read from r, in any order. The packages may depend on packages that aren't in r, and Read may query dst to resolve them, but doesn't change dst.
+	Read(r io.Reader, dst Indexer) ([]*Pkg, error)
+}
+
+// ImporterFunc adapts a function to the Importer interface.
+type ImporterFunc func(r io.Reader, dst Indexer) ([]*Pkg, error)
+
+// Read calls f(r, dst).
+func (f ImporterFunc) Read(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	return f(r, dst)
+}
+
+// Exporter writes the packages of a registry in a format.
+type Exporter interface {
+	Write(w io.Writer, src Walker) error
+}
+
+// ExporterFunc adapts a function to the Exporter interface.
+type ExporterFunc func(w io.Writer, src Walker) error
+
+// Write calls f(w, src).
+func (f ExporterFunc) Write(w io.Writer, src Walker) error {
+	return f(w, src)
+}
+
+// plugins holds the registered importers and exporters, by format.
+var plugins = struct {
+	sync.RWMutex
+	importers map[string]Importer
+	exporters map[string]Exporter
+}{
+	importers: map[string]Importer{},
+	exporters: map[string]Exporter{},
+}
+
+// RegisterImporter makes the importer imp available for format. It panics if an importer is already registered for format.
+func RegisterImporter(format string, imp Importer) {
+	plugins.Lock()
+	defer plugins.Unlock()
+
+	if _, exist := plugins.importers[format]; exist {
+		panic("indexer: importer registered twice for format " + format)
+	}
+	plugins.importers[format] = imp
+}
+
+// RegisterExporter makes the exporter exp available for format. It panics if an exporter is already registered for format.
+func RegisterExporter(format string, exp Exporter) {
+	plugins.Lock()
+	defer plugins.Unlock()
+
+	if _, exist := plugins.exporters[format]; exist {
+		panic("indexer: exporter registered twice for format " + format)
+	}
+	plugins.exporters[format] = exp
+}
+
+// LookupImporter returns the importer registered for format.
+func LookupImporter(format string) (Importer, bool) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	imp, exist := plugins.importers[format]
+	return imp, exist
+}
+
+// LookupExporter returns the exporter registered for format.
+func LookupExporter(format string) (Exporter, bool) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	exp, exist := plugins.exporters[format]
+	return exp, exist
+}
+
+// Formats returns the formats of the registered importers and exporters, in alphabetical order.
+func Formats() (importers, exporters []string) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+
+	for format := range plugins.importers {
+		importers = append(importers, format)
+	}
+	for format := range plugins.exporters {
+		exporters = append(exporters, format)
+	}
+	sort.Strings(importers)
+	sort.Strings(exporters)
+	return importers, exporters
+}
+
+// Import reads the packages of r with the importer registered for format, and loads them into dst. See Load.
+// It returns an error if no importer is registered for format, or if the importer fails to read r.
+func Import(r io.Reader, dst Indexer, format string, opts LoadOptions) (*ImportReport, error) {
+	imp, exist := LookupImporter(format)
+	if !exist {
+		return nil, fmt.Errorf(ErrUnknownImport)
+	}
+
+	pkgs, err := imp.Read(r, dst)
+	if err != nil {
+		return nil, err
+	}
+	return Load(dst, pkgs, opts), nil
+}
+
+// LoadOptions controls how Load indexes packages.
+type LoadOptions struct {
+	// Retries is the number of times the packages that dst didn't index are sent again, once all the others are sent. Retries help when the dependencies of the packages are indexed in the meantime, e.g. by other clients of a server, and when a server fails transiently.
+	Retries int
+}
+
+// ImportReport summarizes the outcome of an import.
+type ImportReport struct {
+	// Indexed holds the names of the indexed packages, in the order they were indexed.
+	Indexed []string
+
+	// Unmet maps the names of the packages that weren't indexed to their dependencies that are neither in the imported data, nor indexed already. The dependencies are in alphabetical order.
+	Unmet map[string][]string
+
+	// Failed holds the names of the packages that the indexer refused, in alphabetical order. They depend on unmet or failed packages, or on each other in a cycle.
+	Failed []string
+
+	// Errors holds the names of the packages that the indexer responded to with Error, in alphabetical order. E.g. a server can't receive the packages whose names hold the delimiters of its messages.
+	Errors []string
+}
+
+// Load indexes pkgs into dst, and reports the outcome. Packages are sent after their dependencies, regardless of their order in pkgs, and the packages with unmet dependencies aren't sent at all.
+// dst may be any Indexer, like an InMemoryIndexer, or a client of a remote server.
+func Load(dst Indexer, pkgs []*Pkg, opts LoadOptions) *ImportReport {
+	imported := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		imported[p.Name] = true
+	}
+
+	report := &ImportReport{Unmet: map[string][]string{}}
+	pending := sortByDeps(pkgs)
+	for round := 0; round <= opts.Retries && len(pending) > 0; round++ {
+		last := round == opts.Retries
+		var retried []*Pkg
+		for _, p := range pending {
+			var unmet []string
+			for _, d := range p.Deps {
+				if !imported[d] && dst.Query(d) != OK {
+					unmet = append(unmet, d)
+				}
+			}
+
+			res := OK
+			if len(unmet) == 0 {
+				res = dst.Index(p)
+			}
+			switch {
+			case len(unmet) == 0 && res == OK:
+				report.Indexed = append(report.Indexed, p.Name)
+			case !last:
+				retried = append(retried, p)
+			case len(unmet) > 0:
+				sort.Strings(unmet)
+				report.Unmet[p.Name] = unmet
+			case res == Error:
+				report.Errors = append(report.Errors, p.Name)
+			default:
+				report.Failed = append(report.Failed, p.Name)
+			}
+		}
+		pending = retried
+	}
+	sort.Strings(report.Failed)
+	sort.Strings(report.Errors)
+	return report
+}
+
+// appendDep appends the dependency d to deps, unless deps holds it already.
+func appendDep(deps []string, d string) []string {
+	for _, dep := range deps {
+		if dep == d {
+			return deps
+		}
+	}
+	return append(deps, d)
+}
+
+// decodeJSON decodes the JSON value read from r into v. It returns an error with the ErrMalformedImport message if the value is malformed, or doesn't fit v.
+func decodeJSON(r io.Reader, v interface{}) error {
+	err := json.NewDecoder(r).Decode(v)
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return fmt.Errorf(ErrMalformedImport)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf(ErrMalformedImport)
+	}
+	return err
+}
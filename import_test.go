@@ -0,0 +1,171 @@
+package indexer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// flakyIndexer refuses to index the packages of refusals a number of times, like a server that fails transiently, and responds with Error to those of errors.
+type flakyIndexer struct {
+	*InMemoryIndexer
+	refusals map[string]int
+	errors   map[string]bool
+}
+
+func (f *flakyIndexer) Index(p *Pkg) string {
+	if f.errors[p.Name] {
+		return Error
+	}
+	if f.refusals[p.Name] > 0 {
+		f.refusals[p.Name]--
+		return Fail
+	}
+	return f.InMemoryIndexer.Index(p)
+}
+
+func TestFormats(t *testing.T) {
+	t.Parallel()
+
+	importers, exporters := Formats()
+	assertNames(t, "importers", importers, []string{FormatDebian, FormatGoMod, FormatGoModGraph, FormatHomebrew, FormatIndex, FormatNpm, FormatRPM})
+	assertNames(t, "exporters", exporters, []string{FormatCycloneDX, FormatDOT, FormatGraphML, FormatIndex, FormatMermaid, FormatSPDX})
+
+	if _, exist := LookupImporter(FormatDOT); exist {
+		t.Errorf("Expected no importer for %q", FormatDOT)
+	}
+	if _, exist := LookupExporter(FormatNpm); exist {
+		t.Errorf("Expected no exporter for %q", FormatNpm)
+	}
+}
+
+func TestRegisterImporter_Twice(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering an importer twice to panic")
+		}
+	}()
+	RegisterImporter(FormatIndex, ImporterFunc(readDump))
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	src := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "zlib", Meta: &Metadata{Version: "1.3"}},
+		{Name: "openssl", Deps: []string{"zlib"}},
+		{Name: "curl", Deps: []string{"openssl", "zlib"}},
+	} {
+		src.Index(p)
+	}
+
+	exp, _ := LookupExporter(FormatIndex)
+	var dump bytes.Buffer
+	if err := exp.Write(&dump, src); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the packages of the dump are imported regardless of their order
+	lines := strings.SplitAfter(strings.TrimSpace(dump.String()), "\n")
+	for a, b := 0, len(lines)-1; a < b; a, b = a+1, b-1 {
+		lines[a], lines[b] = lines[b], lines[a]
+	}
+
+	dst := NewInMemoryIndexer()
+	report, err := Import(strings.NewReader(strings.Join(lines, "\n")), dst, FormatIndex, LoadOptions{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	assertNames(t, "indexed", report.Indexed, []string{"zlib", "openssl", "curl"})
+
+	var out bytes.Buffer
+	if err := Dump(&out, dst); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if out.String() != dump.String() {
+		t.Errorf("Expected dump of imported registry to be %q, but got %q", dump.String(), out.String())
+	}
+
+	// the response to DUMP is imported with the OK line that ends it
+	dst = NewInMemoryIndexer()
+	if _, err := Import(strings.NewReader(dump.String()+OK), dst, FormatIndex, LoadOptions{}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if d := Compare(src, dst); !d.Empty() {
+		t.Errorf("Expected imported registry to be identical, but got %+v", d)
+	}
+}
+
+func TestImport_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Import(strings.NewReader(""), NewInMemoryIndexer(), FormatDOT, LoadOptions{}); err == nil || err.Error() != ErrUnknownImport {
+		t.Errorf("Expected error to be %q, but got %v", ErrUnknownImport, err)
+	}
+
+	for _, data := range []string{"REMOVE|zlib|\n", "INDEX||\n", "INDEX|zlib\n", "OK\nINDEX|zlib|\n"} {
+		if _, err := Import(strings.NewReader(data), NewInMemoryIndexer(), FormatIndex, LoadOptions{}); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	pkgs := []*Pkg{
+		{Name: "curl", Deps: []string{"openssl"}},
+		{Name: "openssl", Deps: []string{"zlib"}},
+		{Name: "zlib"},
+		{Name: "wget", Deps: []string{"openssl", "libidn2"}},
+		{Name: "git", Deps: []string{"curl"}},
+		{Name: "bad|name"},
+	}
+
+	var testCases = []struct {
+		retries  int
+		indexed  []string
+		unmet    map[string][]string
+		failed   []string
+		errors   []string
+		describe string
+	}{
+		{
+			retries:  0,
+			indexed:  []string{"zlib", "openssl"},
+			unmet:    map[string][]string{"wget": {"libidn2"}},
+			failed:   []string{"curl", "git"},
+			errors:   []string{"bad|name"},
+			describe: "without retries",
+		},
+		{
+			retries:  2,
+			indexed:  []string{"zlib", "openssl", "curl", "git"},
+			unmet:    map[string][]string{"wget": {"libidn2"}},
+			errors:   []string{"bad|name"},
+			describe: "with retries",
+		},
+	}
+
+	for _, testCase := range testCases {
+		dst := &flakyIndexer{
+			InMemoryIndexer: NewInMemoryIndexer(),
+			refusals:        map[string]int{"curl": 1},
+			errors:          map[string]bool{"bad|name": true},
+		}
+		report := Load(dst, pkgs, LoadOptions{Retries: testCase.retries})
+
+		assertNames(t, testCase.describe+": indexed", report.Indexed, testCase.indexed)
+		assertNames(t, testCase.describe+": failed", report.Failed, testCase.failed)
+		assertNames(t, testCase.describe+": errors", report.Errors, testCase.errors)
+		if len(report.Unmet) != len(testCase.unmet) {
+			t.Errorf("%s: Expected unmet dependencies to be %v, but got %v", testCase.describe, testCase.unmet, report.Unmet)
+		}
+		for name, unmet := range testCase.unmet {
+			assertNames(t, testCase.describe+": unmet dependencies of "+name, report.Unmet[name], unmet)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package indexer
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 const (
 	// OK is returned to the user when the requested operation succeeded.
@@ -20,17 +23,40 @@ type Indexer interface {
 	Query(string) string
 }
 
+// Walker is implemented by indexers whose registry can be enumerated.
+type Walker interface {
+	// Walk calls fn for every indexed package, in alphabetical order, until fn returns false.
+	Walk(fn func(*Pkg) bool)
+}
+
 // InMemoryIndexer holds an in-memory registry.
+//
+// The registry is split into shards, each with its own lock. Operations on a single package share the lock m, and only lock the shards of the package and its dependencies, so that operations on unrelated packages proceed in parallel.
+// Operations that span the whole registry, like committing a transaction, hold m exclusively instead, and so do the changes to the set of branches.
 type InMemoryIndexer struct {
-	registry map[string]*Pkg
-	m        *sync.Mutex
+	registry *registry
+	branches map[string]*Branch
+	pending  *pending
+	waiters  *waiters
+	m        *sync.RWMutex
+
+	// jm guards the journal and the watches, such that the changes are numbered and published in the same order. It is taken after the locks of the shards.
+	journal *journal
+	watches map[*Watch]bool
+	jm      *sync.Mutex
 }
 
 // NewInMemoryIndexer returns a new InMemoryIndexer instance.
 func NewInMemoryIndexer() *InMemoryIndexer {
 	return &InMemoryIndexer{
-		registry: map[string]*Pkg{},
-		m:        &sync.Mutex{},
+		registry: newRegistry(),
+		branches: map[string]*Branch{},
+		journal:  &journal{},
+		pending:  newPending(),
+		waiters:  newWaiters(),
+		watches:  map[*Watch]bool{},
+		m:        &sync.RWMutex{},
+		jm:       &sync.Mutex{},
 	}
 }
 
@@ -38,18 +64,36 @@ func NewInMemoryIndexer() *InMemoryIndexer {
 // It returns OK if p could be indexed or if it was already present.
 // It returns Fail if p cannot be indexed because some of its dependencies aren't indexed yet and need to be installed first.
 func (i *InMemoryIndexer) Index(p *Pkg) string {
-	i.m.Lock()
-	defer i.m.Unlock()
+	// the pending packages that depend on p are indexed once the locks are released
+	defer i.settle()
 
-	if _, exist := i.registry[p.Name]; exist {
+	return i.index(p)
+}
+
+// index indexes p like Index, without indexing the pending packages that depend on it.
+func (i *InMemoryIndexer) index(p *Pkg) string {
+	// clients often send repeated messages, which don't need the write lock
+	if i.Query(p.Name) == OK {
 		return OK
 	}
 
-	if !i.canIndex(p) {
-		return Fail
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	locked := i.registry.lock(p.Name, p.Deps, true)
+	defer i.registry.unlock(locked)
+
+	if i.registry.has(p.Name) {
+		return OK
 	}
 
-	i.registry[p.Name] = p
+	for _, d := range p.Deps {
+		if !i.registry.has(d) {
+			return Fail
+		}
+	}
+
+	i.put(p.Name, p)
 	return OK
 }
 
@@ -57,18 +101,26 @@ func (i *InMemoryIndexer) Index(p *Pkg) string {
 // It returns OK if name could be removed from the index, or if name wasn't indexed.
 // It returns Fail if name could not be removed from the index because some other indexed package depends on it.
 func (i *InMemoryIndexer) Remove(name string) string {
-	i.m.Lock()
-	defer i.m.Unlock()
+	if i.Query(name) == Fail {
+		return OK
+	}
+
+	i.m.RLock()
+	defer i.m.RUnlock()
 
-	if _, exist := i.registry[name]; !exist {
+	// the dependencies of name are locked too, as their dependents change
+	locked := i.registry.lockIndexed(name, true)
+	defer i.registry.unlock(locked)
+
+	if !i.registry.has(name) {
 		return OK
 	}
 
-	if !i.canRemove(name) {
+	if i.registry.dependents(name) > 0 {
 		return Fail
 	}
 
-	delete(i.registry, name)
+	i.put(name, nil)
 	return OK
 }
 
@@ -76,20 +128,134 @@ func (i *InMemoryIndexer) Remove(name string) string {
 // It returns OK if the package is indexed.
 // It returns Fail if the package isn't indexed.
 func (i *InMemoryIndexer) Query(name string) string {
-	if _, exist := i.registry[name]; exist {
+	i.m.RLock()
+	defer i.m.RUnlock()
+
+	locked := i.registry.lock(name, nil, false)
+	defer i.registry.unlock(locked)
+
+	if i.registry.has(name) {
 		return OK
 	}
 
 	return Fail
 }
 
+// Walk calls fn for every package indexed in i, in alphabetical order, until fn returns false.
+// fn sees a snapshot of the registry taken when Walk is called, and may call the other methods of i.
+func (i *InMemoryIndexer) Walk(fn func(*Pkg) bool) {
+	i.rlock()
+	pkgs := make([]*Pkg, 0, i.registry.count())
+	i.registry.ascend("", func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+	i.runlock()
+
+	for _, p := range pkgs {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// put indexes p as name in the registry, and records the change in the journal. A nil p removes name from the registry.
+// If name is already indexed, p must have the same dependencies, and only its metadata is replaced.
+// The caller must hold i.m exclusively, or hold it shared together with the lock of the shard of name exclusively, and the locks of the shards of the dependencies of name shared.
+func (i *InMemoryIndexer) put(name string, p *Pkg) {
+	for _, b := range i.branches {
+		b.preserve(name, p)
+	}
+
+	if p == nil {
+		i.record(opRemove, i.registry.delete(name), nil)
+		i.waiters.notify(name, true)
+		return
+	}
+
+	// the registry keeps its own copy of the metadata, which the caller may modify afterwards
+	if p.Meta != nil {
+		p = &Pkg{Name: p.Name, Deps: p.Deps, Meta: p.Meta.clone()}
+	}
+
+	if prev := i.registry.insert(p); prev != nil {
+		i.record(opAnnotate, p, prev)
+		return
+	}
+	i.record(opIndex, p, nil)
+	i.pending.indexed(name)
+	i.waiters.notify(name, false)
+}
+
+// record records a change of command op in the journal, and publishes it to the watches.
+func (i *InMemoryIndexer) record(op string, p, prev *Pkg) {
+	i.jm.Lock()
+	defer i.jm.Unlock()
+
+	i.publish(i.journal.record(op, p, prev))
+}
+
+// apply writes the operations of o to the registry, in order.
+// The caller must hold i.m exclusively.
+func (i *InMemoryIndexer) apply(o *overlay) {
+	for _, op := range o.ops {
+		i.put(op.name, op.pkg)
+	}
+}
+
+// rlock locks i such that its registry can be read as a whole, but not changed, until runlock is called.
+func (i *InMemoryIndexer) rlock() {
+	i.m.RLock()
+	i.registry.rlockAll()
+}
+
+func (i *InMemoryIndexer) runlock() {
+	i.registry.runlockAll()
+	i.m.RUnlock()
+}
+
 func (i *InMemoryIndexer) count() int {
-	return len(i.registry)
+	i.rlock()
+	defer i.runlock()
+
+	return i.registry.count()
 }
 
-func (i *InMemoryIndexer) canIndex(p *Pkg) bool {
+// view is a read-only collection of indexed packages that the dependency constraints are checked against.
+type view interface {
+	lookup(name string) (*Pkg, bool)
+
+	// each calls fn for every package in the view, until fn returns false.
+	each(fn func(*Pkg) bool)
+
+	// dependents returns the number of packages in the view that depend on the indexed package name.
+	dependents(name string) int
+}
+
+// pkgMap is a plain map of package names to packages.
+type pkgMap map[string]*Pkg
+
+func (m pkgMap) lookup(name string) (*Pkg, bool) {
+	p, exist := m[name]
+	return p, exist
+}
+
+func (m pkgMap) each(fn func(*Pkg) bool) {
+	for _, p := range m {
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// dependents scans m, which doesn't count the dependents of its packages.
+func (m pkgMap) dependents(name string) int {
+	return len(dependentsOf(m, name))
+}
+
+func canIndex(v view, p *Pkg) bool {
 	for _, d := range p.Deps {
-		if _, exist := i.registry[d]; !exist {
+		if _, exist := v.lookup(d); !exist {
 			return false
 		}
 	}
@@ -97,15 +263,18 @@ func (i *InMemoryIndexer) canIndex(p *Pkg) bool {
 	return true
 }
 
-func (i *InMemoryIndexer) canRemove(name string) bool {
-	ok := true
-	for _, p := range i.registry {
+// dependentsOf returns the names of the packages in v that depend on name, in alphabetical order. Unlike v.dependents, it scans the whole view.
+func dependentsOf(v view, name string) []string {
+	var names []string
+	v.each(func(p *Pkg) bool {
 		for _, dep := range p.Deps {
 			if dep == name {
-				ok = false
+				names = append(names, p.Name)
 				break
 			}
 		}
-	}
-	return ok
+		return true
+	})
+	sort.Strings(names)
+	return names
 }
@@ -1,6 +1,8 @@
 package indexer
 
 import (
+	"math/rand"
+	"strconv"
 	"sync"
 	"testing"
 )
@@ -233,7 +235,7 @@ func TestQuery_ConcurrentRequests(t *testing.T) {
 // assertExist asserts that pkg are indexed in i.
 // It also compares the dependencies of pkg with that returned by i.
 func assertExist(i *InMemoryIndexer, pkg *Pkg, t *testing.T) {
-	p, exist := i.registry[pkg.Name]
+	p, exist := i.registry.lookup(pkg.Name)
 	if !exist {
 		t.Errorf("Expected package %q to be indexed", pkg.Name)
 	}
@@ -253,7 +255,7 @@ func assertExist(i *InMemoryIndexer, pkg *Pkg, t *testing.T) {
 
 // assertNotExist asserts that pkg are not indexed in i.
 func assertNotExist(i *InMemoryIndexer, pkg *Pkg, t *testing.T) {
-	if _, exist := i.registry[pkg.Name]; exist {
+	if _, exist := i.registry.lookup(pkg.Name); exist {
 		t.Errorf("Expected package %q to be removed", pkg.Name)
 	}
 }
@@ -261,6 +263,129 @@ func assertNotExist(i *InMemoryIndexer, pkg *Pkg, t *testing.T) {
 // seedRegistry is a helper function to help add pkgs to i.
 func seedRegistry(i *InMemoryIndexer, pkgs ...*Pkg) {
 	for _, p := range pkgs {
-		i.registry[p.Name] = p
+		i.registry.insert(p)
 	}
 }
+
+func TestConcurrentStress(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	names := make([]string, 64)
+	for k := range names {
+		names[k] = "pkg-" + strconv.Itoa(k)
+	}
+
+	// writers randomly index and remove packages that depend on lower-numbered packages, while readers query and walk the registry
+	const workers, ops = 8, 2000
+	w := &sync.WaitGroup{}
+	w.Add(workers * 2)
+	for k := 0; k < workers; k++ {
+		go func(seed int64) {
+			defer w.Done()
+			r := rand.New(rand.NewSource(seed))
+			for n := 0; n < ops; n++ {
+				k := r.Intn(len(names))
+				if r.Intn(2) == 0 {
+					fixture.Remove(names[k])
+					continue
+				}
+
+				p := &Pkg{Name: names[k]}
+				for d := 0; d < k && len(p.Deps) < 3; d += 1 + r.Intn(8) {
+					p.Deps = append(p.Deps, names[d])
+				}
+				fixture.Index(p)
+			}
+		}(int64(k))
+
+		go func(seed int64) {
+			defer w.Done()
+			r := rand.New(rand.NewSource(seed))
+			for n := 0; n < ops; n++ {
+				if n%100 == 0 {
+					assertConsistent(fixture, t)
+					continue
+				}
+				fixture.Query(names[r.Intn(len(names))])
+			}
+		}(int64(k))
+	}
+	w.Wait()
+
+	assertConsistent(fixture, t)
+}
+
+func BenchmarkQuery(b *testing.B) {
+	fixture := benchmarkFixture(10000)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		fixture.Query("pkg-" + strconv.Itoa(n%10000))
+	}
+}
+
+func BenchmarkQuery_Parallel(b *testing.B) {
+	fixture := benchmarkFixture(10000)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			fixture.Query("pkg-" + strconv.Itoa(n%10000))
+			n++
+		}
+	})
+}
+
+func BenchmarkReadMostly_Parallel(b *testing.B) {
+	fixture := benchmarkFixture(10000)
+	b.ResetTimer()
+
+	// one in 20 operations is a write
+	b.RunParallel(func(pb *testing.PB) {
+		n := 0
+		for pb.Next() {
+			name := "pkg-" + strconv.Itoa(n%10000)
+			switch n % 20 {
+			case 0:
+				fixture.Remove(name)
+			case 10:
+				fixture.Index(&Pkg{Name: name})
+			default:
+				fixture.Query(name)
+			}
+			n++
+		}
+	})
+}
+
+// assertConsistent asserts that the dependencies of every package indexed in i are indexed too.
+func assertConsistent(i *InMemoryIndexer, t *testing.T) {
+	indexed := map[string]*Pkg{}
+	i.Walk(func(p *Pkg) bool {
+		indexed[p.Name] = p
+		return true
+	})
+
+	for _, p := range indexed {
+		for _, d := range p.Deps {
+			if _, exist := indexed[d]; !exist {
+				t.Errorf("Expected dependency %q of %q to be indexed", d, p.Name)
+			}
+		}
+	}
+}
+
+// benchmarkFixture returns an indexer with n packages, each depending on up to 2 of the previous packages.
+func benchmarkFixture(n int) *InMemoryIndexer {
+	i := NewInMemoryIndexer()
+	for k := 0; k < n; k++ {
+		p := &Pkg{Name: "pkg-" + strconv.Itoa(k)}
+		for d := k / 2; d < k && len(p.Deps) < 2; d++ {
+			p.Deps = append(p.Deps, "pkg-"+strconv.Itoa(d))
+		}
+		i.Index(p)
+	}
+	return i
+}
@@ -0,0 +1,208 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// ErrRevisionUnavailable is an error message indicating a revision is older than the oldest change kept in the journal.
+	ErrRevisionUnavailable = "Revision is no longer available"
+
+	// ErrRevisionUnknown is an error message indicating a revision is newer than the current revision.
+	ErrRevisionUnknown = "Revision is unknown"
+
+	// journalLimit is the maximum number of changes kept in the journal.
+	journalLimit = 1 << 16
+
+	opIndex    = "INDEX"
+	opRemove   = "REMOVE"
+	opAnnotate = "ANNOTATE"
+)
+
+// Journaler is implemented by indexers that keep a journal of the changes to their registry, and can roll them back.
+type Journaler interface {
+	Revision() uint64
+	Changes(since uint64) ([]Change, error)
+	Undo(n int) error
+	RollbackTo(rev uint64) error
+}
+
+// Change records a mutation of the registry.
+type Change struct {
+	// Rev is the revision of the registry produced by the change.
+	Rev uint64
+
+	// Op is the command that mutated the registry. It is either "INDEX", "REMOVE" or "ANNOTATE".
+	Op string
+
+	// Pkg is the indexed or removed package. For ANNOTATE changes, it is the package with its new metadata.
+	Pkg *Pkg
+
+	// Prev is the package with its previous metadata, for ANNOTATE changes. It is nil for the other changes.
+	Prev *Pkg
+}
+
+// Conflict describes a change that can't be rolled back without violating the dependencies constraints.
+type Conflict struct {
+	Change Change
+	Reason string
+}
+
+// ConflictError is returned when some changes can't be rolled back. None of the changes are rolled back in that case.
+type ConflictError struct {
+	Conflicts []Conflict
+}
+
+func (e *ConflictError) Error() string {
+	reasons := make([]string, len(e.Conflicts))
+	for k, c := range e.Conflicts {
+		reasons[k] = fmt.Sprintf("%s of %q at revision %d: %s", c.Change.Op, c.Change.Pkg.Name, c.Change.Rev, c.Reason)
+	}
+	return "Can't roll back " + strings.Join(reasons, "; ")
+}
+
+// journal keeps the most recent changes of a registry, in order.
+type journal struct {
+	// rev is the current revision of the registry.
+	rev     uint64
+	changes []Change
+}
+
+// record appends a change of command op to the journal, and returns it.
+func (j *journal) record(op string, p, prev *Pkg) Change {
+	j.rev++
+	c := Change{Rev: j.rev, Op: op, Pkg: p, Prev: prev}
+	j.changes = append(j.changes, c)
+
+	// drop the oldest changes in bulk to amortize the copying
+	if len(j.changes) > journalLimit {
+		j.changes = append([]Change(nil), j.changes[len(j.changes)-journalLimit/2:]...)
+	}
+	return c
+}
+
+// since returns all the changes after revision rev.
+func (j *journal) since(rev uint64) ([]Change, error) {
+	if rev > j.rev {
+		return nil, fmt.Errorf(ErrRevisionUnknown)
+	}
+
+	oldest := j.rev - uint64(len(j.changes))
+	if rev < oldest {
+		return nil, fmt.Errorf(ErrRevisionUnavailable)
+	}
+
+	return j.changes[rev-oldest:], nil
+}
+
+// Revision returns the current revision of the registry of i. The revision is incremented by every change to the registry.
+func (i *InMemoryIndexer) Revision() uint64 {
+	i.jm.Lock()
+	defer i.jm.Unlock()
+
+	return i.journal.rev
+}
+
+// Changes returns the changes made to the registry of i after revision since, in order.
+// It returns an error if since is newer than the current revision, or if some of the changes are no longer kept in the journal.
+func (i *InMemoryIndexer) Changes(since uint64) ([]Change, error) {
+	i.jm.Lock()
+	defer i.jm.Unlock()
+
+	changes, err := i.journal.since(since)
+	if err != nil {
+		return nil, err
+	}
+	return append([]Change(nil), changes...), nil
+}
+
+// Undo rolls back the last n changes to the registry of i. See RollbackTo.
+func (i *InMemoryIndexer) Undo(n int) error {
+	defer i.settle()
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	if n < 0 || uint64(n) > i.journal.rev {
+		return fmt.Errorf(ErrRevisionUnavailable)
+	}
+	return i.rollback(i.journal.rev - uint64(n))
+}
+
+// RollbackTo rolls back all the changes to the registry of i after revision rev, such that the registry returns to its state at rev.
+// The rollback itself is recorded as new changes, and doesn't rewind the revision.
+// It returns a *ConflictError if some of the changes can't be rolled back without violating the dependencies constraints. In that case, none of the changes are rolled back.
+func (i *InMemoryIndexer) RollbackTo(rev uint64) error {
+	defer i.settle()
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	return i.rollback(rev)
+}
+
+// rollback reverts the changes after rev, in reverse order.
+// The caller must hold i.m exclusively.
+func (i *InMemoryIndexer) rollback(rev uint64) error {
+	changes, err := i.journal.since(rev)
+	if err != nil {
+		return err
+	}
+
+	o := newOverlay(i.registry)
+	var conflicts []Conflict
+	for k := len(changes) - 1; k >= 0; k-- {
+		c := changes[k]
+		if reason := revert(o, c); reason != "" {
+			conflicts = append(conflicts, Conflict{Change: c, Reason: reason})
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return &ConflictError{Conflicts: conflicts}
+	}
+
+	i.apply(o)
+	return nil
+}
+
+// revert applies the inverse of c to o.
+// It returns the reason why c can't be reverted, or an empty string if it is reverted.
+func revert(o *overlay, c Change) string {
+	p, exist := o.lookup(c.Pkg.Name)
+	switch c.Op {
+	case opIndex:
+		if !exist || !sameDeps(p, c.Pkg) {
+			return "package has been changed since"
+		}
+
+		// the dependents are only listed when there are some, as that scans the registry
+		if o.dependents(c.Pkg.Name) > 0 {
+			return "package is depended on by " + strings.Join(dependentsOf(o, c.Pkg.Name), depsDelimiter)
+		}
+
+		o.remove(c.Pkg.Name)
+	case opRemove:
+		if exist {
+			return "package has been indexed since"
+		}
+
+		var missing []string
+		for _, d := range c.Pkg.Deps {
+			if _, exist := o.lookup(d); !exist {
+				missing = append(missing, d)
+			}
+		}
+		if len(missing) > 0 {
+			return "dependencies are missing: " + strings.Join(missing, depsDelimiter)
+		}
+
+		o.index(c.Pkg)
+	case opAnnotate:
+		if !exist || !sameMeta(p.Meta, c.Pkg.Meta) {
+			return "package has been changed since"
+		}
+
+		o.annotate(c.Pkg.Name, c.Prev.Meta)
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package indexer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	fixture.Index(pcre)
+	fixture.Index(nginx)
+	fixture.Index(nginx) // no-op
+	fixture.Remove(nginx.Name)
+
+	if rev := fixture.Revision(); rev != 3 {
+		t.Errorf("Expected revision to be 3, but got %d", rev)
+	}
+
+	changes, err := fixture.Changes(1)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	expected := []Change{{Rev: 2, Op: "INDEX", Pkg: nginx}, {Rev: 3, Op: "REMOVE", Pkg: nginx}}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, but got %d", len(expected), len(changes))
+	}
+	for k, c := range changes {
+		if c.Rev != expected[k].Rev || c.Op != expected[k].Op || c.Pkg.Name != nginx.Name || !sameDeps(c.Pkg, nginx) {
+			t.Errorf("Expected change %d to be %+v, but got %+v", k, expected[k], c)
+		}
+	}
+
+	if _, err := fixture.Changes(4); err == nil || err.Error() != ErrRevisionUnknown {
+		t.Errorf("Expected error to be %q, but got %v", ErrRevisionUnknown, err)
+	}
+}
+
+func TestChanges_Unavailable(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	p := &Pkg{Name: "mysql"}
+	for k := 0; k <= journalLimit/2; k++ {
+		fixture.Index(p)
+		fixture.Remove(p.Name)
+	}
+
+	if _, err := fixture.Changes(0); err == nil || err.Error() != ErrRevisionUnavailable {
+		t.Errorf("Expected error to be %q, but got %v", ErrRevisionUnavailable, err)
+	}
+
+	if _, err := fixture.Changes(fixture.Revision() - 10); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name, zlib.Name}}
+	for _, p := range []*Pkg{pcre, zlib, nginx} {
+		fixture.Index(p)
+	}
+	fixture.Remove(nginx.Name)
+	fixture.Remove(zlib.Name)
+
+	// re-index zlib and nginx
+	if err := fixture.Undo(2); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	assertExist(fixture, zlib, t)
+	assertExist(fixture, nginx, t)
+
+	// the rollback is recorded as new changes
+	if rev := fixture.Revision(); rev != 7 {
+		t.Errorf("Expected revision to be 7, but got %d", rev)
+	}
+
+	if err := fixture.RollbackTo(0); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if count := fixture.count(); count != 0 {
+		t.Errorf("Expected registry to be empty, but got %d packages", count)
+	}
+
+	if err := fixture.Undo(100); err == nil || err.Error() != ErrRevisionUnavailable {
+		t.Errorf("Expected error to be %q, but got %v", ErrRevisionUnavailable, err)
+	}
+}
+
+func TestRollbackTo_Conflict(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	fixture.Index(pcre)
+	fixture.Index(zlib)
+
+	// bypass the journal, such that rolling back pcre breaks nginx
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, nginx)
+
+	err := fixture.RollbackTo(0)
+	c, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("Expected a conflict error, but got %v", err)
+	}
+
+	if len(c.Conflicts) != 1 || c.Conflicts[0].Change.Pkg.Name != pcre.Name {
+		t.Fatalf("Expected a conflict on %q, but got %+v", pcre.Name, c.Conflicts)
+	}
+	if !strings.Contains(c.Conflicts[0].Reason, nginx.Name) {
+		t.Errorf("Expected conflict reason to mention %q, but got %q", nginx.Name, c.Conflicts[0].Reason)
+	}
+
+	// expect none of the changes to be rolled back
+	assertExist(fixture, pcre, t)
+	assertExist(fixture, zlib, t)
+}
+
+func BenchmarkUndo(b *testing.B) {
+	// every package depends on previous ones, so that the changes are undone in dependency order
+	const n = 10000
+	for k := 0; k < b.N; k++ {
+		b.StopTimer()
+		fixture := benchmarkFixture(n)
+		b.StartTimer()
+
+		if err := fixture.Undo(n); err != nil {
+			b.Fatal("Unexpected error: ", err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package indexer
+
+import "strings"
+
+// Lister is implemented by indexers that can list their packages in alphabetical order, a page at a time.
+type Lister interface {
+	List(opts ListOptions) *Page
+}
+
+// ListOptions selects the packages returned by List.
+type ListOptions struct {
+	// Prefix selects the packages whose names start with Prefix.
+	Prefix string
+
+	// Start selects the packages whose names aren't less than Start.
+	Start string
+
+	// End selects the packages whose names are less than End. An empty End doesn't bound the list.
+	End string
+
+	// Limit is the maximum number of packages returned. A zero Limit returns all the selected packages.
+	Limit int
+
+	// Cursor resumes a listing from the Next of its previous page.
+	Cursor string
+
+	// Selector selects the packages whose labels meet its requirements. An empty Selector selects all the packages.
+	Selector Selector
+}
+
+// from returns the name the listing starts from.
+func (o ListOptions) from() string {
+	from := o.Prefix
+	for _, s := range []string{o.Start, o.Cursor} {
+		if s > from {
+			from = s
+		}
+	}
+	return from
+}
+
+// selects returns true if name is selected by o, provided it isn't less than the start of the listing.
+func (o ListOptions) selects(name string) bool {
+	return strings.HasPrefix(name, o.Prefix) && (o.End == "" || name < o.End)
+}
+
+// Page is a page of packages returned by List.
+type Page struct {
+	// Pkgs holds the packages of the page, in alphabetical order.
+	Pkgs []*Pkg
+
+	// Next is the cursor of the following page. It is empty if this is the last page.
+	Next string
+}
+
+// List returns the packages indexed in i that are selected by opts, in alphabetical order.
+// If opts.Limit is reached, the returned page holds a cursor that resumes the listing. Listing pages one after another sees every package that stays indexed throughout, exactly once.
+func (i *InMemoryIndexer) List(opts ListOptions) *Page {
+	i.rlock()
+	defer i.runlock()
+
+	return i.scan(opts.from(), opts.Limit, func(p *Pkg) (selected, stop bool) {
+		// packages are visited in order, so none of the following ones are selected either
+		if !opts.selects(p.Name) {
+			return false, true
+		}
+		return opts.Selector.selects(p), false
+	})
+}
+
+// scan returns a page of the packages indexed in i whose names aren't less than start, and that are selected by fn, in alphabetical order.
+// fn stops the scan by returning stop. A zero limit doesn't limit the size of the page.
+// The caller must hold i such that its registry can't change.
+func (i *InMemoryIndexer) scan(start string, limit int, fn func(p *Pkg) (selected, stop bool)) *Page {
+	page := &Page{}
+	i.registry.ascend(start, func(p *Pkg) bool {
+		selected, stop := fn(p)
+		if stop {
+			return false
+		}
+		if !selected {
+			return true
+		}
+
+		if limit > 0 && len(page.Pkgs) == limit {
+			page.Next = p.Name
+			return false
+		}
+
+		page.Pkgs = append(page.Pkgs, p)
+		return true
+	})
+	return page
+}
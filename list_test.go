@@ -0,0 +1,67 @@
+package indexer
+
+import "testing"
+
+func TestList(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	for _, name := range []string{"zlib", "libxml2", "libcurl", "libcurl-dev", "nginx", "libyaml", "openssl"} {
+		fixture.Index(&Pkg{Name: name})
+	}
+
+	var tests = []struct {
+		opts     ListOptions
+		expected []string
+		next     string
+	}{
+		{opts: ListOptions{}, expected: []string{"libcurl", "libcurl-dev", "libxml2", "libyaml", "nginx", "openssl", "zlib"}},
+		{opts: ListOptions{Prefix: "lib"}, expected: []string{"libcurl", "libcurl-dev", "libxml2", "libyaml"}},
+		{opts: ListOptions{Prefix: "lib", Start: "libx"}, expected: []string{"libxml2", "libyaml"}},
+		{opts: ListOptions{Start: "libd", End: "openssl"}, expected: []string{"libxml2", "libyaml", "nginx"}},
+		{opts: ListOptions{Prefix: "lib", Limit: 2}, expected: []string{"libcurl", "libcurl-dev"}, next: "libxml2"},
+		{opts: ListOptions{Prefix: "lib", Limit: 2, Cursor: "libxml2"}, expected: []string{"libxml2", "libyaml"}},
+		{opts: ListOptions{Prefix: "perl"}, expected: nil},
+	}
+
+	for _, test := range tests {
+		page := fixture.List(test.opts)
+		names := make([]string, len(page.Pkgs))
+		for k, p := range page.Pkgs {
+			names[k] = p.Name
+		}
+		assertNames(t, "listed packages", names, test.expected)
+
+		if page.Next != test.next {
+			t.Errorf("Expected next cursor of %+v to be %q, but got %q", test.opts, test.next, page.Next)
+		}
+	}
+}
+
+func TestList_Pages(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "a"})
+	fixture.Index(&Pkg{Name: "b"})
+	fixture.Index(&Pkg{Name: "c"})
+	fixture.Index(&Pkg{Name: "d"})
+
+	first := fixture.List(ListOptions{Limit: 2})
+
+	// packages removed and indexed between pages don't disturb the listing
+	fixture.Remove("c")
+	fixture.Index(&Pkg{Name: "a0"})
+	fixture.Index(&Pkg{Name: "e"})
+
+	names := []string{}
+	for page := first; ; page = fixture.List(ListOptions{Limit: 2, Cursor: page.Next}) {
+		for _, p := range page.Pkgs {
+			names = append(names, p.Name)
+		}
+		if page.Next == "" {
+			break
+		}
+	}
+	assertNames(t, "listed packages", names, []string{"a", "b", "d", "e"})
+}
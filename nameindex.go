@@ -0,0 +1,120 @@
+package indexer
+
+import "sort"
+
+// blockSize is the maximum number of ids held by a block of a nameIndex.
+const blockSize = 512
+
+// nameIndex is an ordered set of the ids of indexed packages, sorted by the names of the packages.
+// The ids are split into sorted blocks of at most blockSize ids, such that an insertion or a deletion only moves the ids of a single block, and a scan can start anywhere with two binary searches.
+//
+// The methods of nameIndex take the interned names of the registry, which must include the names of all the ids involved.
+type nameIndex struct {
+	blocks [][]uint32
+}
+
+// insert adds id to x, unless it is already present.
+func (x *nameIndex) insert(names []string, id uint32) {
+	b, k := x.search(names, names[id])
+	switch {
+	case len(x.blocks) == 0:
+		x.blocks = append(x.blocks, make([]uint32, 0, blockSize))
+	case b == len(x.blocks):
+		// id goes after all the others, at the end of the last block
+		b--
+		k = len(x.blocks[b])
+	case x.blocks[b][k] == id:
+		return
+	}
+
+	block := append(x.blocks[b], 0)
+	copy(block[k+1:], block[k:])
+	block[k] = id
+	x.blocks[b] = block
+
+	if len(block) > blockSize {
+		x.split(b)
+	}
+}
+
+// delete removes id from x, if it is present.
+func (x *nameIndex) delete(names []string, id uint32) {
+	b, k := x.search(names, names[id])
+	if b == len(x.blocks) || x.blocks[b][k] != id {
+		return
+	}
+
+	block := x.blocks[b]
+	x.blocks[b] = append(block[:k], block[k+1:]...)
+	if len(x.blocks[b]) == 0 {
+		x.blocks = append(x.blocks[:b], x.blocks[b+1:]...)
+	}
+}
+
+// ascend calls fn for every id in x whose name isn't less than start, in order, until fn returns false.
+func (x *nameIndex) ascend(names []string, start string, fn func(id uint32) bool) {
+	for c := x.seek(names, start); !c.done(); c.next() {
+		if !fn(c.id()) {
+			return
+		}
+	}
+}
+
+// nameCursor is a position in a nameIndex, which is only valid as long as the index doesn't change.
+type nameCursor struct {
+	x    *nameIndex
+	b, k int
+}
+
+// seek returns the position of the first id in x whose name isn't less than start.
+func (x *nameIndex) seek(names []string, start string) nameCursor {
+	b, k := x.search(names, start)
+	return nameCursor{x: x, b: b, k: k}
+}
+
+// done returns true if c is past the last id.
+func (c *nameCursor) done() bool {
+	return c.b == len(c.x.blocks)
+}
+
+func (c *nameCursor) id() uint32 {
+	return c.x.blocks[c.b][c.k]
+}
+
+// next moves c to the following id.
+func (c *nameCursor) next() {
+	if c.k++; c.k == len(c.x.blocks[c.b]) {
+		c.b, c.k = c.b+1, 0
+	}
+}
+
+// search returns the position of the first id in x whose name isn't less than name, as the indices of its block and of the id in the block.
+// The block index is len(x.blocks) if there is no such id.
+func (x *nameIndex) search(names []string, name string) (b, k int) {
+	b = sort.Search(len(x.blocks), func(b int) bool {
+		block := x.blocks[b]
+		return names[block[len(block)-1]] >= name
+	})
+	if b == len(x.blocks) {
+		return b, 0
+	}
+
+	block := x.blocks[b]
+	k = sort.Search(len(block), func(k int) bool {
+		return names[block[k]] >= name
+	})
+	return b, k
+}
+
+// split splits block b of x in halves.
+func (x *nameIndex) split(b int) {
+	block := x.blocks[b]
+	half := len(block) / 2
+
+	right := make([]uint32, len(block)-half, blockSize)
+	copy(right, block[half:])
+
+	x.blocks = append(x.blocks, nil)
+	copy(x.blocks[b+2:], x.blocks[b+1:])
+	x.blocks[b], x.blocks[b+1] = block[:half], right
+}
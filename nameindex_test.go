@@ -0,0 +1,60 @@
+package indexer
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestNameIndex(t *testing.T) {
+	t.Parallel()
+
+	names := make([]string, 4*blockSize)
+	for k := range names {
+		names[k] = "pkg-" + strconv.Itoa(k)
+	}
+
+	// insert and delete ids at random, such that blocks are split and dropped
+	x := &nameIndex{}
+	expected := map[string]bool{}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 20*len(names); n++ {
+		id := uint32(r.Intn(len(names)))
+		if r.Intn(3) > 0 {
+			x.insert(names, id)
+			expected[names[id]] = true
+		} else {
+			x.delete(names, id)
+			delete(expected, names[id])
+		}
+	}
+
+	sorted := make([]string, 0, len(expected))
+	for name := range expected {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var actual []string
+	x.ascend(names, "", func(id uint32) bool {
+		actual = append(actual, names[id])
+		return true
+	})
+	assertNames(t, "index", actual, sorted)
+
+	for _, block := range x.blocks {
+		if len(block) == 0 || len(block) > blockSize {
+			t.Errorf("Expected blocks to hold between 1 and %d ids, but got %d", blockSize, len(block))
+		}
+	}
+
+	// start a scan in the middle of the index
+	start := sorted[len(sorted)/2]
+	var tail []string
+	x.ascend(names, start, func(id uint32) bool {
+		tail = append(tail, names[id])
+		return len(tail) < 3
+	})
+	assertNames(t, "scan", tail, sorted[len(sorted)/2:len(sorted)/2+3])
+}
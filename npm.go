@@ -0,0 +1,157 @@
+package indexer
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// npmLock is the shape of a package-lock.json file.
+type npmLock struct {
+	Name            string                `json:"name"`
+	LockfileVersion int                   `json:"lockfileVersion"`
+	Packages        map[string]npmPackage `json:"packages"`
+}
+
+type npmPackage struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	License  string `json:"license"`
+	Resolved string `json:"resolved"`
+	Link     bool   `json:"link"`
+
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	PeerDependenciesMeta map[string]struct {
+		Optional bool `json:"optional"`
+	} `json:"peerDependenciesMeta"`
+}
+
+func init() {
+	RegisterImporter(FormatNpm, ImporterFunc(readNpm))
+}
+
+// ImportNpm reads an npm package-lock.json file of lockfileVersion 2 or 3 from r, and indexes a package per name and version of its packages into dst, in dependency order.
+// The packages are named after their names and versions, like debug@2.6.9, such that the versions of a package that npm nests under different paths are different packages, and the copies of the same version are the same package. The root package and the workspaces are named after their names if they aren't versioned.
+// The dependencies of a package are resolved from its path, like node does: from its own node_modules directory, and then from those of its parents. The dev, optional and peer dependencies are dependencies too, but the optional ones that npm didn't install are dropped. The versions and the licenses are the metadata of the packages.
+// It returns an error if r can't be read, or isn't a lockfile of version 2 or 3. Otherwise, the report lists the packages whose dependencies are unmet, and those that dst refused.
+func ImportNpm(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatNpm, LoadOptions{})
+}
+
+// readNpm reads the packages of ImportNpm from r.
+func readNpm(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	var lock npmLock
+	if err := decodeJSON(r, &lock); err != nil {
+		return nil, err
+	}
+	if lock.LockfileVersion < 2 || lock.Packages == nil {
+		return nil, fmt.Errorf(ErrMalformedImport)
+	}
+
+	paths := make([]string, 0, len(lock.Packages))
+	for p := range lock.Packages {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	// target returns the path of the package installed at p, following links to workspaces
+	target := func(p string) string {
+		if entry := lock.Packages[p]; entry.Link && entry.Resolved != "" {
+			return entry.Resolved
+		}
+		return p
+	}
+
+	name := func(p string) string {
+		entry := lock.Packages[target(p)]
+		n := entry.Name
+		if n == "" {
+			n = npmName(p)
+		}
+		if n == "" {
+			n = lock.Name
+		}
+		if entry.Version != "" {
+			n += "@" + entry.Version
+		}
+		return n
+	}
+
+	// resolve returns the path of the package dep, as required from the path from, or an empty string if it isn't installed
+	resolve := func(from, dep string) string {
+		dir := from
+		for {
+			candidate := path.Join(dir, "node_modules", dep)
+			if _, exist := lock.Packages[candidate]; exist {
+				return candidate
+			}
+			if dir == "" {
+				return ""
+			}
+
+			if k := strings.LastIndex(dir, "node_modules/"); k >= 0 {
+				dir = strings.TrimSuffix(dir[:k], "/")
+			} else {
+				dir = ""
+			}
+		}
+	}
+
+	var pkgs []*Pkg
+	seen := map[string]bool{}
+	for _, from := range paths {
+		entry := lock.Packages[from]
+		if entry.Link {
+			continue
+		}
+
+		p := &Pkg{Name: name(from), Meta: (&Metadata{Version: entry.Version, License: entry.License}).clone()}
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+
+		required := map[string]bool{}
+		for _, deps := range []map[string]string{entry.Dependencies, entry.DevDependencies, entry.PeerDependencies} {
+			for dep := range deps {
+				required[dep] = !entry.PeerDependenciesMeta[dep].Optional
+			}
+		}
+		for dep := range entry.OptionalDependencies {
+			required[dep] = false
+		}
+
+		deps := make([]string, 0, len(required))
+		for dep := range required {
+			deps = append(deps, dep)
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if resolved := resolve(from, dep); resolved != "" {
+				if d := name(resolved); d != p.Name {
+					p.Deps = appendDep(p.Deps, d)
+				}
+			} else if required[dep] {
+				p.Deps = appendDep(p.Deps, dep)
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// npmName returns the name of the package installed at the path p, like @babel/core for node_modules/@babel/core, or the base of p if it isn't in a node_modules directory. The root package has no path, and no name.
+func npmName(p string) string {
+	if p == "" {
+		return ""
+	}
+	if k := strings.LastIndex(p, "node_modules/"); k >= 0 {
+		return p[k+len("node_modules/"):]
+	}
+	return path.Base(p)
+}
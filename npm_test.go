@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportNpm(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/package-lock.json")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer f.Close()
+
+	fixture := NewInMemoryIndexer()
+	report, err := ImportNpm(f, fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// jest-snapshot isn't in the lockfile, so @jest/core, jest and the root package can't be indexed
+	assertNames(t, "unmet dependencies of @jest/core", report.Unmet["@jest/core@29.7.0"], []string{"jest-snapshot"})
+	assertNames(t, "failed", report.Failed, []string{"jest@29.7.0", "storefront@1.0.0"})
+	if len(report.Indexed) != 9 {
+		t.Errorf("Expected 9 packages to be indexed, but got %v", report.Indexed)
+	}
+
+	// the nested versions of debug and ms are distinct packages, while the copies of lodash are the same package
+	var tests = []struct {
+		name string
+		deps []string
+	}{
+		{name: "express@4.18.2", deps: []string{"debug@2.6.9", "ms@2.0.0"}},
+		{name: "debug@2.6.9", deps: []string{"ms@2.0.0"}},
+		{name: "debug@4.3.4", deps: []string{"ms@2.1.2"}},
+		{name: "ui", deps: []string{"debug@4.3.4", "react@18.2.0"}},
+		{name: "lodash@4.17.21", deps: nil},
+		{name: "lodash-es@4.17.21", deps: nil},
+	}
+	for _, test := range tests {
+		p := fixture.Describe(test.name)
+		if p == nil {
+			t.Errorf("Expected %q to be indexed", test.name)
+			continue
+		}
+		assertNames(t, "dependencies of "+test.name, p.Deps, test.deps)
+	}
+	if p := fixture.Describe("ms@2.0.0"); p == nil || p.Meta.Version != "2.0.0" || p.Meta.License != "MIT" {
+		t.Errorf("Expected metadata of ms@2.0.0, but got %+v", p)
+	}
+
+	// the root package depends on the ui workspace, but not on the optional fsevents that isn't installed
+	fixture.Index(&Pkg{Name: "jest-snapshot"})
+	f.Seek(0, 0)
+	if report, err = ImportNpm(f, fixture); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(report.Unmet) != 0 || len(report.Failed) != 0 {
+		t.Errorf("Expected all the packages to be indexed, but got %+v", report)
+	}
+	assertNames(t, "dependencies of the root package", fixture.Describe("storefront@1.0.0").Deps, []string{"express@4.18.2", "jest@29.7.0", "ui"})
+}
+
+func TestImportNpm_Malformed(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"", `{"lockfileVersion": 1, "dependencies": {}}`, `{"lockfileVersion": 3}`, `{"lockfileVersion": 3, "packages": []}`} {
+		if _, err := ImportNpm(strings.NewReader(data), NewInMemoryIndexer()); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package indexer
+
+// sortByDeps orders pkgs such that every package comes after those of its dependencies that are in pkgs.
+// Packages that don't depend on each other are kept in their original order. Packages in a dependency cycle are ordered arbitrarily.
+func sortByDeps(pkgs []*Pkg) []*Pkg {
+	index := make(map[string]int, len(pkgs))
+	for k, p := range pkgs {
+		index[p.Name] = k
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(pkgs))
+	sorted := make([]*Pkg, 0, len(pkgs))
+
+	// depth-first post-order walk, with an explicit stack to cope with long dependency chains
+	type frame struct {
+		k    int
+		next int
+	}
+	for root := range pkgs {
+		if state[root] != unvisited {
+			continue
+		}
+
+		stack := []frame{{k: root}}
+		state[root] = visiting
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			deps := pkgs[top.k].Deps
+			if top.next < len(deps) {
+				dep, ok := index[deps[top.next]]
+				top.next++
+				if ok && state[dep] == unvisited {
+					state[dep] = visiting
+					stack = append(stack, frame{k: dep})
+				}
+				continue
+			}
+
+			state[top.k] = visited
+			sorted = append(sorted, pkgs[top.k])
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return sorted
+}
+
+// reverse returns pkgs in reverse order.
+func reverse(pkgs []*Pkg) []*Pkg {
+	reversed := make([]*Pkg, len(pkgs))
+	for k, p := range pkgs {
+		reversed[len(pkgs)-1-k] = p
+	}
+	return reversed
+}
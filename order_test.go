@@ -0,0 +1,42 @@
+package indexer
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSortByDeps(t *testing.T) {
+	pkgs := []*Pkg{
+		{Name: "nginx", Deps: []string{"pcre-8.38", "zlib-1.2.8", "libc"}},
+		{Name: "mysql"},
+		{Name: "zlib-1.2.8", Deps: []string{"libc"}},
+		{Name: "pcre-8.38"},
+		{Name: "libc"},
+	}
+
+	sorted := sortByDeps(pkgs)
+	assertPkgNames(t, "sorted", sorted, "pcre-8.38", "libc", "zlib-1.2.8", "nginx", "mysql")
+}
+
+func TestSortByDeps_Cycle(t *testing.T) {
+	pkgs := []*Pkg{
+		{Name: "a", Deps: []string{"b"}},
+		{Name: "b", Deps: []string{"a"}},
+		{Name: "c", Deps: []string{"a"}},
+	}
+
+	sorted := sortByDeps(pkgs)
+	assertPkgNames(t, "sorted", sorted, "b", "a", "c")
+}
+
+func TestSortByDeps_LongChain(t *testing.T) {
+	var pkgs []*Pkg
+	for k := 0; k < 100000; k++ {
+		pkgs = append(pkgs, &Pkg{Name: strconv.Itoa(k), Deps: []string{strconv.Itoa(k + 1)}})
+	}
+
+	sorted := sortByDeps(pkgs)
+	if len(sorted) != len(pkgs) || sorted[0] != pkgs[len(pkgs)-1] {
+		t.Error("Expected the last package in the chain to come first")
+	}
+}
@@ -2,6 +2,8 @@ package indexer
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -10,7 +12,10 @@ const (
 	msgDelimiter       = "|"
 	msgDelimitersCount = 2
 	depsDelimiter      = ","
-	splitsMax          = 3
+	splitsMax          = 4
+	depsField          = 2
+	metaField          = 3
+	metaLabelPrefix    = "label."
 
 	// ErrMalformedMsg is an error message indicating a malformed message structure.
 	ErrMalformedMsg = "Malformed message structure"
@@ -20,9 +25,32 @@ const (
 
 	// ErrMissingName is an wrror message indicating a package name is missing.
 	ErrMissingName = "Missing package name"
+
+	// ErrMalformedMeta is an error message indicating the metadata of a package is malformed.
+	ErrMalformedMeta = "Malformed package metadata"
 )
 
+// namelessCmds are commands that don't necessarily refer to any package, and hence their package name may be left empty.
+// E.g. "BEGIN||\n".
+var namelessCmds = map[string]bool{
+	"BEGIN":       true,
+	"COMMIT":      true,
+	"ABORT":       true,
+	"CHECKOUT":    true,
+	"BRANCHES":    true,
+	"REVISION":    true,
+	"DUMP":        true,
+	"LIST":        true,
+	"STATS":       true,
+	"EXPORT":      true,
+	"SBOM":        true,
+	"PENDING":     true,
+	"SUBSCRIBE":   true,
+	"UNSUBSCRIBE": true,
+}
+
 // ParseMsg extracts the package and command information from s.
+// A message may end with an optional metadata field, e.g. INDEX|zlib||version=1.2.11&license=Zlib. See ParseMeta.
 func ParseMsg(s string) (p *Pkg, cmd string, e error) {
 	if !isWellStructured(s) {
 		return nil, "", fmt.Errorf(ErrMalformedMsg)
@@ -33,11 +61,108 @@ func ParseMsg(s string) (p *Pkg, cmd string, e error) {
 		return nil, "", err
 	}
 
+	meta, err := extractMeta(splits)
+	if err != nil {
+		return nil, "", err
+	}
+
 	cmd = splits[0]
-	p = &Pkg{Name: splits[1], Deps: extractDeps(splits)}
+	p = &Pkg{Name: splits[1], Deps: extractDeps(splits), Meta: meta}
 	return
 }
 
+// FormatMsg formats p into a message of command cmd. It is the inverse of ParseMsg.
+// The metadata field is only included if p has metadata.
+func FormatMsg(cmd string, p *Pkg) string {
+	msg := cmd + msgDelimiter + p.Name + msgDelimiter + strings.Join(p.Deps, depsDelimiter)
+	if !p.Meta.empty() {
+		msg += msgDelimiter + FormatMeta(p.Meta)
+	}
+	return msg + msgSuffix
+}
+
+// FormatMeta formats m into the metadata field of a message, as URL-encoded key=value pairs separated by &, e.g. version=1.2.11&license=Zlib&label.team=core.
+// The keys are description, version, license, maintainer, homepage, size and label.<key>, and only the fields that are set are included.
+func FormatMeta(m *Metadata) string {
+	if m.empty() {
+		return ""
+	}
+
+	v := url.Values{}
+	for key, value := range map[string]string{
+		"description": m.Description,
+		"version":     m.Version,
+		"license":     m.License,
+		"maintainer":  m.Maintainer,
+		"homepage":    m.Homepage,
+	} {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	if m.Size != 0 {
+		v.Set("size", strconv.FormatInt(m.Size, 10))
+	}
+	for key, value := range m.Labels {
+		v.Set(metaLabelPrefix+key, value)
+	}
+
+	// Encode sorts the keys, such that the same metadata is always formatted the same way
+	return v.Encode()
+}
+
+// ParseMeta parses the metadata field of a message. It is the inverse of FormatMeta.
+// It returns nil if s doesn't hold any metadata, and an error if s is malformed or has unknown keys.
+func ParseMeta(s string) (*Metadata, error) {
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, fmt.Errorf(ErrMalformedMeta)
+	}
+
+	m := &Metadata{}
+	for key, vs := range values {
+		if len(vs) != 1 {
+			return nil, fmt.Errorf(ErrMalformedMeta)
+		}
+
+		value := vs[0]
+		switch key {
+		case "description":
+			m.Description = value
+		case "version":
+			m.Version = value
+		case "license":
+			m.License = value
+		case "maintainer":
+			m.Maintainer = value
+		case "homepage":
+			m.Homepage = value
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf(ErrMalformedMeta)
+			}
+			m.Size = size
+		default:
+			label := strings.TrimPrefix(key, metaLabelPrefix)
+			if label == key || label == "" {
+				return nil, fmt.Errorf(ErrMalformedMeta)
+			}
+
+			if m.Labels == nil {
+				m.Labels = map[string]string{}
+			}
+			m.Labels[label] = value
+		}
+	}
+	return m.clone(), nil
+}
+
+// FormatChange formats c into a line of the form <revision>|<command>|<package>|<dependencies>.
+func FormatChange(c Change) string {
+	return strconv.FormatUint(c.Rev, 10) + msgDelimiter + FormatMsg(c.Op, c.Pkg)
+}
+
 func isWellStructured(s string) bool {
 	if !strings.HasSuffix(s, msgSuffix) {
 		return false
@@ -52,11 +177,15 @@ func isWellStructured(s string) bool {
 
 func extractFields(s string) ([]string, error) {
 	splits := strings.Split(strings.TrimSpace(s), msgDelimiter)
+	if len(splits) > splitsMax {
+		return nil, fmt.Errorf(ErrMalformedMsg)
+	}
+
 	if splits[0] == "" {
 		return nil, fmt.Errorf(ErrMissingCmd)
 	}
 
-	if splits[1] == "" {
+	if splits[1] == "" && !namelessCmds[splits[0]] {
 		return nil, fmt.Errorf(ErrMissingName)
 	}
 
@@ -65,8 +194,15 @@ func extractFields(s string) ([]string, error) {
 
 func extractDeps(splits []string) []string {
 	var deps []string
-	if len(splits) == splitsMax && splits[splitsMax-1] != "" {
-		deps = strings.Split(splits[splitsMax-1], depsDelimiter)
+	if len(splits) > depsField && splits[depsField] != "" {
+		deps = strings.Split(splits[depsField], depsDelimiter)
 	}
 	return deps
 }
+
+func extractMeta(splits []string) (*Metadata, error) {
+	if len(splits) <= metaField {
+		return nil, nil
+	}
+	return ParseMeta(splits[metaField])
+}
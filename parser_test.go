@@ -14,6 +14,9 @@ func TestParseMessage_WellFormedMsg(t *testing.T) {
 		{command: "INDEX", name: "ceylon", msg: "INDEX|ceylon|\n", expected: nil},
 		{command: "REMOVE", name: "cloog", msg: "REMOVE|cloog|\n", expected: nil},
 		{command: "QUERY", name: "cloog", msg: "QUERY|cloog|\n", expected: nil},
+		{command: "BEGIN", msg: "BEGIN||\n", expected: nil},
+		{command: "COMMIT", msg: "COMMIT||\n", expected: nil},
+		{command: "ABORT", msg: "ABORT||\n", expected: nil},
 	}
 
 	for _, test := range tests {
@@ -61,3 +64,51 @@ func TestParseMessage_BrokenMsg(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMessage_Meta(t *testing.T) {
+	msg := "INDEX|zlib||description=A+massively+spiffy+compression+library&label.team=core&license=Zlib&size=108544&version=1.2.11\n"
+	p, cmd, err := ParseMsg(msg)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	expected := &Metadata{
+		Description: "A massively spiffy compression library",
+		Version:     "1.2.11",
+		License:     "Zlib",
+		Size:        108544,
+		Labels:      map[string]string{"team": "core"},
+	}
+	if cmd != "INDEX" || p.Name != "zlib" || len(p.Deps) != 0 || !sameMeta(p.Meta, expected) {
+		t.Errorf("Expected message to hold %+v, but got %+v", expected, p.Meta)
+	}
+
+	// formatting the package gives the message back
+	if actual := FormatMsg(cmd, p); actual != msg {
+		t.Errorf("Expected formatted message to be %q, but got %q", msg, actual)
+	}
+
+	if actual := FormatMsg(cmd, &Pkg{Name: "zlib", Meta: &Metadata{}}); actual != "INDEX|zlib|\n" {
+		t.Errorf("Expected empty metadata to be left out, but got %q", actual)
+	}
+}
+
+func TestParseMessage_BrokenMeta(t *testing.T) {
+	var tests = []struct {
+		msg    string
+		reason string
+	}{
+		{msg: "INDEX|zlib||color=blue\n", reason: "Key is unknown"},
+		{msg: "INDEX|zlib||label.=core\n", reason: "Label key is missing"},
+		{msg: "INDEX|zlib||size=big\n", reason: "Size isn't a number"},
+		{msg: "INDEX|zlib||version=1&version=2\n", reason: "Key is repeated"},
+		{msg: "INDEX|zlib||version=%zz\n", reason: "Value is badly escaped"},
+		{msg: "INDEX|zlib|||version=1\n", reason: "Too many fields"},
+	}
+
+	for _, test := range tests {
+		if _, _, err := ParseMsg(test.msg); err == nil {
+			t.Error("Expected error didn't occur. Should fail because", test.reason)
+		}
+	}
+}
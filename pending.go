@@ -0,0 +1,242 @@
+package indexer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Pending is returned to the user when a deferred package is parked until its dependencies are indexed.
+const Pending = "PENDING\n"
+
+// Deferrer is implemented by indexers that can park the packages whose dependencies aren't indexed yet, and index them once they are.
+type Deferrer interface {
+	// Defer indexes p like Index, or parks p as pending if some of its dependencies aren't indexed. See InMemoryIndexer.Defer.
+	Defer(p *Pkg) (res string, landed <-chan string)
+
+	// Pending returns the pending packages, in alphabetical order.
+	Pending() []PendingPkg
+
+	// Cancel drops the pending package name.
+	Cancel(name string) string
+}
+
+// PendingPkg is a package parked until its dependencies are indexed.
+type PendingPkg struct {
+	Pkg *Pkg
+
+	// Missing holds the dependencies of Pkg that aren't indexed, in alphabetical order.
+	Missing []string
+}
+
+// pending holds the packages parked by Defer, and the packages to index as soon as possible, because some of their dependencies have just been indexed.
+// Its lock is taken after i.m and the locks of the shards, and must not be held while locking them.
+type pending struct {
+	sync.Mutex
+	pkgs map[string]*parked
+
+	// waiting maps the dependencies of the pending packages to the names of their dependents.
+	waiting map[string]map[string]bool
+
+	// ready holds the names of the pending packages that may be indexed.
+	ready []string
+
+	// queued is the length of ready, such that settle doesn't lock q after every operation when no package is ready, which is the common case.
+	queued atomic.Int64
+
+	// parked is the number of pending packages, such that indexed doesn't lock q when none is pending.
+	parked atomic.Int64
+
+	// settling is set while a call of settle indexes the ready packages. The other calls leave the packages that become ready to that one.
+	settling bool
+}
+
+// parked is a pending package, along with the channels that are notified once it is indexed or cancelled.
+type parked struct {
+	pkg       *Pkg
+	listeners []chan string
+}
+
+func newPending() *pending {
+	return &pending{pkgs: map[string]*parked{}, waiting: map[string]map[string]bool{}}
+}
+
+// park adds p to the pending packages, and returns a channel notified once p is indexed or cancelled.
+// It returns false if another package called p.Name is pending with other dependencies.
+func (q *pending) park(p *Pkg) (<-chan string, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	entry, exist := q.pkgs[p.Name]
+	if exist && !sameDeps(entry.pkg, p) {
+		return nil, false
+	}
+	if !exist {
+		entry = &parked{pkg: p}
+		q.pkgs[p.Name] = entry
+		q.parked.Store(int64(len(q.pkgs)))
+		for _, d := range p.Deps {
+			if q.waiting[d] == nil {
+				q.waiting[d] = map[string]bool{}
+			}
+			q.waiting[d][p.Name] = true
+		}
+	}
+
+	c := make(chan string, 1)
+	entry.listeners = append(entry.listeners, c)
+	return c, true
+}
+
+// indexed notifies q that name has been indexed. If name is pending, it is dropped and its listeners receive OK. The pending packages that depend on name become ready.
+// A package parked concurrently is parked before it is indexed, and thus sees name as indexed.
+func (q *pending) indexed(name string) {
+	if q.parked.Load() == 0 {
+		return
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	q.drop(name, OK)
+	for dependent := range q.waiting[name] {
+		q.ready = append(q.ready, dependent)
+	}
+	q.queued.Store(int64(len(q.ready)))
+}
+
+// drop removes name from the pending packages, and sends res to its listeners.
+// It returns false if name isn't pending. The caller must hold q.
+func (q *pending) drop(name, res string) bool {
+	entry, exist := q.pkgs[name]
+	if !exist {
+		return false
+	}
+
+	delete(q.pkgs, name)
+	q.parked.Store(int64(len(q.pkgs)))
+	for _, d := range entry.pkg.Deps {
+		delete(q.waiting[d], name)
+		if len(q.waiting[d]) == 0 {
+			delete(q.waiting, d)
+		}
+	}
+	for _, c := range entry.listeners {
+		c <- res
+		close(c)
+	}
+	return true
+}
+
+// claim marks q as settling. It returns false if another call of settle is settling q already.
+func (q *pending) claim() bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.settling {
+		return false
+	}
+	q.settling = true
+	return true
+}
+
+// next pops a ready package, if any. Once there is none, q is no longer settling.
+func (q *pending) next() (*Pkg, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.ready) > 0 {
+		name := q.ready[0]
+		q.ready = q.ready[1:]
+		q.queued.Store(int64(len(q.ready)))
+		if entry, exist := q.pkgs[name]; exist {
+			return entry.pkg, true
+		}
+	}
+	q.settling = false
+	return nil, false
+}
+
+// Defer indexes p like Index if all its dependencies are indexed. Otherwise, p is parked as pending, and indexed as soon as its last missing dependency is, by whichever operation indexes that dependency, or by a concurrent one that is already indexing pending packages.
+// It returns OK if p is indexed, Pending if p is parked, and Fail if another package called p.Name is pending with other dependencies.
+// Unless res is Fail, landed receives OK once p is indexed, or Fail if p is cancelled first, and is then closed. A package that is indexed by other means, like Index or a transaction, is no longer pending, and landed receives OK too.
+func (i *InMemoryIndexer) Defer(p *Pkg) (res string, landed <-chan string) {
+	// p is parked before it is indexed, such that the dependencies indexed concurrently wake it up
+	landed, ok := i.pending.park(p)
+	if !ok {
+		return Fail, nil
+	}
+
+	if i.Index(p) == OK {
+		i.landed(p.Name)
+		return OK, landed
+	}
+	return Pending, landed
+}
+
+// Pending returns the packages parked by Defer, in alphabetical order, along with their dependencies that aren't indexed.
+func (i *InMemoryIndexer) Pending() []PendingPkg {
+	i.pending.Lock()
+	pkgs := make([]*Pkg, 0, len(i.pending.pkgs))
+	for _, entry := range i.pending.pkgs {
+		pkgs = append(pkgs, entry.pkg)
+	}
+	i.pending.Unlock()
+	sort.Sort(byName(pkgs))
+
+	i.rlock()
+	defer i.runlock()
+
+	res := make([]PendingPkg, 0, len(pkgs))
+	for _, p := range pkgs {
+		entry := PendingPkg{Pkg: p}
+		for _, d := range p.Deps {
+			if !i.registry.has(d) {
+				entry.Missing = append(entry.Missing, d)
+			}
+		}
+		sort.Strings(entry.Missing)
+		res = append(res, entry)
+	}
+	return res
+}
+
+// Cancel drops the package name from the packages parked by Defer, whose listeners receive Fail.
+// It returns OK if name was pending, and Fail otherwise.
+func (i *InMemoryIndexer) Cancel(name string) string {
+	i.pending.Lock()
+	defer i.pending.Unlock()
+
+	if !i.pending.drop(name, Fail) {
+		return Fail
+	}
+	return OK
+}
+
+// landed drops name from the pending packages once it is indexed, in case it was already indexed when Index was called.
+func (i *InMemoryIndexer) landed(name string) {
+	i.pending.Lock()
+	defer i.pending.Unlock()
+
+	i.pending.drop(name, OK)
+}
+
+// settle indexes the pending packages whose dependencies have been indexed. Those that still miss some dependencies stay pending.
+// It must be called once the operations that index packages release their locks.
+// The packages that become ready as settle indexes others are indexed by the same loop, rather than recursively. If another call is already settling, settle leaves the ready packages to it and returns right away.
+func (i *InMemoryIndexer) settle() {
+	if i.pending.queued.Load() == 0 || !i.pending.claim() {
+		return
+	}
+
+	for {
+		p, ok := i.pending.next()
+		if !ok {
+			return
+		}
+
+		if i.index(p) == OK {
+			i.landed(p.Name)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package indexer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestDefer(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	if res, landed := i.Defer(&Pkg{Name: "zlib"}); res != OK || <-landed != OK {
+		t.Errorf("Expected package without dependencies to be indexed, but got %q", res)
+	}
+
+	res, curl := i.Defer(&Pkg{Name: "curl", Deps: []string{"openssl", "zlib", "nghttp2"}})
+	if res != Pending {
+		t.Fatalf("Expected response to be %q, but got %q", Pending, res)
+	}
+	if res, _ := i.Defer(&Pkg{Name: "curl", Deps: []string{"openssl"}}); res != Fail {
+		t.Errorf("Expected deferring a pending package with other dependencies to fail, but got %q", res)
+	}
+	_, git := i.Defer(&Pkg{Name: "git", Deps: []string{"curl"}})
+
+	pending := i.Pending()
+	if len(pending) != 2 || pending[0].Pkg.Name != "curl" || pending[1].Pkg.Name != "git" {
+		t.Fatalf("Expected curl and git to be pending, but got %+v", pending)
+	}
+	assertNames(t, "missing dependencies of curl", pending[0].Missing, []string{"nghttp2", "openssl"})
+	assertNames(t, "missing dependencies of git", pending[1].Missing, []string{"curl"})
+
+	// curl isn't indexed until its last missing dependency is
+	i.Index(&Pkg{Name: "openssl", Deps: []string{"zlib"}})
+	if res := i.Query("curl"); res != Fail {
+		t.Errorf("Expected curl not to be indexed yet, but got %q", res)
+	}
+
+	// indexing nghttp2 lands curl, and then git
+	i.Index(&Pkg{Name: "nghttp2"})
+	for name, landed := range map[string]<-chan string{"curl": curl, "git": git} {
+		if res, ok := <-landed; res != OK || !ok {
+			t.Errorf("Expected %s to land, but got %q", name, res)
+		}
+		if _, ok := <-landed; ok {
+			t.Errorf("Expected the channel of %s to be closed", name)
+		}
+		if res := i.Query(name); res != OK {
+			t.Errorf("Expected %s to be indexed, but got %q", name, res)
+		}
+	}
+	if pending := i.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending packages, but got %+v", pending)
+	}
+}
+
+func TestDefer_Cancel(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	_, landed := i.Defer(&Pkg{Name: "curl", Deps: []string{"openssl"}})
+	if res := i.Cancel("curl"); res != OK {
+		t.Errorf("Expected response to be %q, but got %q", OK, res)
+	}
+	if res := <-landed; res != Fail {
+		t.Errorf("Expected cancelled package to be notified with %q, but got %q", Fail, res)
+	}
+	if res := i.Cancel("curl"); res != Fail {
+		t.Errorf("Expected cancelling a package that isn't pending to fail, but got %q", res)
+	}
+
+	i.Index(&Pkg{Name: "openssl"})
+	if res := i.Query("curl"); res != Fail {
+		t.Errorf("Expected cancelled package not to be indexed, but got %q", res)
+	}
+}
+
+func TestDefer_IndexedByOthers(t *testing.T) {
+	t.Parallel()
+
+	// a pending package that lands by other means is no longer pending
+	i := NewInMemoryIndexer()
+	i.Index(&Pkg{Name: "openssl"})
+	_, landed := i.Defer(&Pkg{Name: "curl", Deps: []string{"openssl", "nghttp2"}})
+
+	tx := i.Begin()
+	tx.Index(&Pkg{Name: "curl", Deps: []string{"openssl"}})
+	if res := tx.Commit(); res != OK {
+		t.Fatalf("Expected response to be %q, but got %q", OK, res)
+	}
+	if res := <-landed; res != OK {
+		t.Errorf("Expected curl to land, but got %q", res)
+	}
+	if pending := i.Pending(); len(pending) != 0 {
+		t.Errorf("Expected no pending packages, but got %+v", pending)
+	}
+
+	// the dependencies indexed by a transaction land the pending packages
+	_, landed = i.Defer(&Pkg{Name: "wget", Deps: []string{"libidn2"}})
+	tx = i.Begin()
+	tx.Index(&Pkg{Name: "libidn2"})
+	tx.Commit()
+	if res := <-landed; res != OK || i.Query("wget") != OK {
+		t.Errorf("Expected wget to land, but got %q", res)
+	}
+}
+
+func TestDefer_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	// every package depends on the previous one, and they are deferred concurrently in any order
+	const n = 50
+	i := NewInMemoryIndexer()
+	var wg sync.WaitGroup
+	landed := make([]<-chan string, n)
+	for k := n - 1; k >= 0; k-- {
+		p := &Pkg{Name: fmt.Sprintf("pkg-%d", k)}
+		if k > 0 {
+			p.Deps = []string{fmt.Sprintf("pkg-%d", k-1)}
+		}
+
+		wg.Add(1)
+		go func(k int, p *Pkg) {
+			defer wg.Done()
+			_, landed[k] = i.Defer(p)
+		}(k, p)
+	}
+	wg.Wait()
+
+	for k, c := range landed {
+		if res := <-c; res != OK {
+			t.Errorf("Expected pkg-%d to land, but got %q", k, res)
+		}
+	}
+	if count := i.count(); count != n {
+		t.Errorf("Expected %d packages to be indexed, but got %d", n, count)
+	}
+}
+
+func TestDefer_Chain(t *testing.T) {
+	t.Parallel()
+
+	// every package depends on the previous one, and they are all pending until the first one is indexed
+	const n = 10000
+	i := NewInMemoryIndexer()
+	landed := make([]<-chan string, n)
+	for k := n - 1; k > 0; k-- {
+		_, landed[k] = i.Defer(&Pkg{Name: fmt.Sprintf("pkg-%d", k), Deps: []string{fmt.Sprintf("pkg-%d", k-1)}})
+	}
+
+	// while another call settles the registry, the packages that become ready are left to it
+	i.pending.claim()
+	i.Index(&Pkg{Name: "pkg-0"})
+	if res := i.Query("pkg-1"); res != Fail {
+		t.Errorf("Expected pkg-1 not to be indexed yet, but got %q", res)
+	}
+
+	i.pending.settling = false
+	i.settle()
+	for k := 1; k < n; k++ {
+		if res := <-landed[k]; res != OK {
+			t.Fatalf("Expected pkg-%d to land, but got %q", k, res)
+		}
+	}
+	if count := i.count(); count != n {
+		t.Errorf("Expected %d packages to be indexed, but got %d", n, count)
+	}
+}
@@ -4,4 +4,64 @@ package indexer
 type Pkg struct {
 	Name string
 	Deps []string
+
+	// Meta holds the optional metadata of the package. It is nil if the package has none.
+	// The metadata of the packages returned by an Indexer is shared, and must not be modified.
+	Meta *Metadata
+}
+
+// Metadata describes a package. All of its fields are optional.
+type Metadata struct {
+	Description string
+	Version     string
+	License     string
+	Maintainer  string
+	Homepage    string
+
+	// Size is the size of the package in bytes.
+	Size int64
+
+	// Labels holds arbitrary key/value pairs, e.g. team=payments.
+	Labels map[string]string
+}
+
+// clone returns a deep copy of m. It returns nil if m is empty.
+func (m *Metadata) clone() *Metadata {
+	if m.empty() {
+		return nil
+	}
+
+	c := *m
+	if len(m.Labels) > 0 {
+		c.Labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			c.Labels[k] = v
+		}
+	} else {
+		c.Labels = nil
+	}
+	return &c
+}
+
+// empty returns true if m is nil, or if none of its fields are set.
+func (m *Metadata) empty() bool {
+	return m == nil || (m.Description == "" && m.Version == "" && m.License == "" && m.Maintainer == "" && m.Homepage == "" && m.Size == 0 && len(m.Labels) == 0)
+}
+
+// sameMeta returns true if a and b hold the same metadata. Nil and empty metadata are the same.
+func sameMeta(a, b *Metadata) bool {
+	if a.empty() || b.empty() {
+		return a.empty() && b.empty()
+	}
+
+	if a.Description != b.Description || a.Version != b.Version || a.License != b.License || a.Maintainer != b.Maintainer || a.Homepage != b.Homepage || a.Size != b.Size || len(a.Labels) != len(b.Labels) {
+		return false
+	}
+
+	for k, v := range a.Labels {
+		if w, exist := b.Labels[k]; !exist || w != v {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,385 @@
+package indexer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrMalformedQuery is an error message indicating a graph query expression is malformed.
+const ErrMalformedQuery = "Malformed query expression"
+
+// Evaluator is implemented by indexers that can answer questions about their dependency graph with query expressions.
+type Evaluator interface {
+	Evaluate(expr string, opts SearchOptions) (*Page, error)
+}
+
+// Evaluate returns the packages indexed in i that the query expression expr evaluates to, and that are selected by opts, in alphabetical order.
+// An expression combines sets of packages with the left-associative operators + (union), - (difference) and & (intersection), which must be surrounded by whitespaces. Parentheses group sub-expressions. The sets are:
+//
+//	nginx         the package nginx, if it is indexed
+//	lib*-dev      the packages whose names match a glob, in the syntax of Search
+//	deps(x)       the direct dependencies of the packages of x
+//	rdeps(x)      the packages that depend directly on the packages of x
+//	closure(x)    the packages of x, along with all their dependencies
+//	rclosure(x)   the packages of x, along with all the packages that depend on them
+//	roots()       the packages that no other package depends on
+//	leaves()      the packages that don't depend on any package
+//
+// E.g. rdeps(openssl) - closure(nginx). It returns an error if expr is malformed. If opts.Limit is reached, the returned page holds a cursor that resumes the evaluation.
+func (i *InMemoryIndexer) Evaluate(expr string, opts SearchOptions) (*Page, error) {
+	q, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	i.rlock()
+	defer i.runlock()
+
+	var pkgs []*Pkg
+	for name := range q.eval(&graph{v: i.registry}) {
+		if p, _ := i.registry.lookup(name); i.filter(p, opts) {
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Sort(byName(pkgs))
+	return paginate(pkgs, opts), nil
+}
+
+// paginate returns the page of pkgs that starts at opts.Cursor, and holds at most opts.Limit packages. pkgs must be in alphabetical order.
+func paginate(pkgs []*Pkg, opts SearchOptions) *Page {
+	start := sort.Search(len(pkgs), func(k int) bool { return pkgs[k].Name >= opts.Cursor })
+	pkgs = pkgs[start:]
+
+	page := &Page{Pkgs: pkgs}
+	if opts.Limit > 0 && len(pkgs) > opts.Limit {
+		page.Pkgs, page.Next = pkgs[:opts.Limit], pkgs[opts.Limit].Name
+	}
+	return page
+}
+
+// nameSet is a set of package names.
+type nameSet map[string]struct{}
+
+// graph evaluates queries against the packages of a view.
+type graph struct {
+	v view
+
+	// rev maps the names of packages to the names of the packages that depend on them. It is built on first use.
+	rev map[string][]string
+}
+
+func (g *graph) dependents(name string) []string {
+	if g.rev == nil {
+		g.rev = map[string][]string{}
+		g.v.each(func(p *Pkg) bool {
+			for _, d := range p.Deps {
+				g.rev[d] = append(g.rev[d], p.Name)
+			}
+			return true
+		})
+	}
+	return g.rev[name]
+}
+
+func (g *graph) dependencies(name string) []string {
+	p, _ := g.v.lookup(name)
+	if p == nil {
+		return nil
+	}
+	return p.Deps
+}
+
+// step returns the names that next returns for the names of s.
+func (g *graph) step(s nameSet, next func(string) []string) nameSet {
+	result := nameSet{}
+	for name := range s {
+		for _, n := range next(name) {
+			result[n] = struct{}{}
+		}
+	}
+	return result
+}
+
+// walk returns the names of s, along with all the names reachable from them through next.
+func (g *graph) walk(s nameSet, next func(string) []string) nameSet {
+	result := nameSet{}
+	var queue []string
+	for name := range s {
+		result[name] = struct{}{}
+		queue = append(queue, name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, n := range next(name) {
+			if _, seen := result[n]; !seen {
+				result[n] = struct{}{}
+				queue = append(queue, n)
+			}
+		}
+	}
+	return result
+}
+
+// all returns the names of the packages for which keep returns true.
+func (g *graph) all(keep func(*Pkg) bool) nameSet {
+	result := nameSet{}
+	g.v.each(func(p *Pkg) bool {
+		if keep(p) {
+			result[p.Name] = struct{}{}
+		}
+		return true
+	})
+	return result
+}
+
+// function is a set function of query expressions, with a fixed number of arguments.
+type function struct {
+	arity int
+	eval  func(g *graph, args []nameSet) nameSet
+}
+
+var functions = map[string]function{
+	"deps": {arity: 1, eval: func(g *graph, args []nameSet) nameSet {
+		return g.step(args[0], g.dependencies)
+	}},
+	"rdeps": {arity: 1, eval: func(g *graph, args []nameSet) nameSet {
+		return g.step(args[0], g.dependents)
+	}},
+	"closure": {arity: 1, eval: func(g *graph, args []nameSet) nameSet {
+		return g.walk(args[0], g.dependencies)
+	}},
+	"rclosure": {arity: 1, eval: func(g *graph, args []nameSet) nameSet {
+		return g.walk(args[0], g.dependents)
+	}},
+	"roots": {arity: 0, eval: func(g *graph, args []nameSet) nameSet {
+		return g.all(func(p *Pkg) bool { return len(g.dependents(p.Name)) == 0 })
+	}},
+	"leaves": {arity: 0, eval: func(g *graph, args []nameSet) nameSet {
+		return g.all(func(p *Pkg) bool { return len(p.Deps) == 0 })
+	}},
+}
+
+// query is a parsed query expression.
+type query interface {
+	eval(g *graph) nameSet
+}
+
+// setOp combines the sets of two sub-expressions with one of the operators +, - and &.
+type setOp struct {
+	op          string
+	left, right query
+}
+
+func (o *setOp) eval(g *graph) nameSet {
+	left, right := o.left.eval(g), o.right.eval(g)
+	result := nameSet{}
+	switch o.op {
+	case "+":
+		for name := range left {
+			result[name] = struct{}{}
+		}
+		for name := range right {
+			result[name] = struct{}{}
+		}
+	case "-":
+		for name := range left {
+			if _, exist := right[name]; !exist {
+				result[name] = struct{}{}
+			}
+		}
+	case "&":
+		for name := range left {
+			if _, exist := right[name]; exist {
+				result[name] = struct{}{}
+			}
+		}
+	}
+	return result
+}
+
+// call applies a function to the sets of its arguments.
+type call struct {
+	fn   function
+	args []query
+}
+
+func (c *call) eval(g *graph) nameSet {
+	args := make([]nameSet, len(c.args))
+	for k, a := range c.args {
+		args[k] = a.eval(g)
+	}
+	return c.fn.eval(g, args)
+}
+
+// pattern selects packages by their names. A nil re selects the package name only.
+type pattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+func (p *pattern) eval(g *graph) nameSet {
+	if p.re == nil {
+		if _, exist := g.v.lookup(p.name); exist {
+			return nameSet{p.name: struct{}{}}
+		}
+		return nameSet{}
+	}
+	return g.all(func(pkg *Pkg) bool { return p.re.MatchString(pkg.Name) })
+}
+
+// parseQuery parses expr into a query, in the syntax described by Evaluate.
+func parseQuery(expr string) (query, error) {
+	p := &queryParser{tokens: tokenize(expr)}
+	q, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "" {
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	}
+	return q, nil
+}
+
+// tokenize splits expr into parentheses, commas and words separated by whitespaces. Operators are words, such that package names can hold them.
+func tokenize(expr string) []string {
+	var tokens []string
+	start := -1
+	for k, c := range expr {
+		if c != '(' && c != ')' && c != ',' && !unicode.IsSpace(c) {
+			if start < 0 {
+				start = k
+			}
+			continue
+		}
+
+		if start >= 0 {
+			tokens = append(tokens, expr[start:k])
+			start = -1
+		}
+		if !unicode.IsSpace(c) {
+			tokens = append(tokens, string(c))
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, expr[start:])
+	}
+	return tokens
+}
+
+// queryParser is a recursive descent parser of query expressions.
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, or an empty string at the end of the expression.
+func (p *queryParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *queryParser) expect(tok string) error {
+	if p.next() != tok {
+		return fmt.Errorf(ErrMalformedQuery)
+	}
+	return nil
+}
+
+// expr parses a sequence of terms separated by operators.
+func (p *queryParser) expr() (query, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for isOperator(p.peek()) {
+		op := p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = &setOp{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// term parses a parenthesized expression, a function call or a name pattern.
+func (p *queryParser) term() (query, error) {
+	tok := p.next()
+	switch {
+	case tok == "(":
+		q, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return q, p.expect(")")
+	case tok == "", tok == ")", tok == ",", isOperator(tok):
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	case p.peek() == "(":
+		return p.call(tok)
+	}
+
+	if !strings.ContainsAny(tok, `*?[\`) {
+		return &pattern{name: tok}, nil
+	}
+	expr, ok := globExpr(tok)
+	if !ok {
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	}
+	return &pattern{name: tok, re: re}, nil
+}
+
+// call parses the parenthesized arguments of the function name.
+func (p *queryParser) call(name string) (query, error) {
+	fn, exist := functions[name]
+	if !exist {
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	}
+
+	p.next()
+	c := &call{fn: fn}
+	if p.peek() != ")" {
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if len(c.args) != fn.arity {
+		return nil, fmt.Errorf(ErrMalformedQuery)
+	}
+	return c, nil
+}
+
+func isOperator(tok string) bool {
+	return tok == "+" || tok == "-" || tok == "&"
+}
@@ -0,0 +1,116 @@
+package indexer
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "openssl"},
+		{Name: "zlib"},
+		{Name: "pcre"},
+		{Name: "nginx", Deps: []string{"openssl", "zlib", "pcre"}},
+		{Name: "libcurl", Deps: []string{"openssl", "zlib"}},
+		{Name: "curl", Deps: []string{"libcurl"}},
+		{Name: "git", Deps: []string{"libcurl", "pcre"}},
+		{Name: "libcurl-dev", Deps: []string{"libcurl"}},
+		{Name: "jq"},
+	} {
+		if res := fixture.Index(p); res != OK {
+			t.Fatalf("Expected %q to be indexed, but got %q", p.Name, res)
+		}
+	}
+
+	var tests = []struct {
+		expr     string
+		expected []string
+	}{
+		{expr: "nginx", expected: []string{"nginx"}},
+		{expr: "lib*", expected: []string{"libcurl", "libcurl-dev"}},
+		{expr: "mysql", expected: nil},
+		{expr: "deps(nginx)", expected: []string{"openssl", "pcre", "zlib"}},
+		{expr: "rdeps(openssl)", expected: []string{"libcurl", "nginx"}},
+		{expr: "rdeps(openssl) - closure(nginx)", expected: []string{"libcurl"}},
+		{expr: "closure(git)", expected: []string{"git", "libcurl", "openssl", "pcre", "zlib"}},
+		{expr: "rclosure(openssl) - openssl", expected: []string{"curl", "git", "libcurl", "libcurl-dev", "nginx"}},
+		{expr: "roots()", expected: []string{"curl", "git", "jq", "libcurl-dev", "nginx"}},
+		{expr: "leaves()", expected: []string{"jq", "openssl", "pcre", "zlib"}},
+		{expr: "roots() & leaves()", expected: []string{"jq"}},
+		{expr: "deps(curl + git) - pcre", expected: []string{"libcurl"}},
+		{expr: "deps(git) + deps(curl) & rdeps(zlib)", expected: []string{"libcurl"}},
+		{expr: "deps(git) + (deps(curl) & rdeps(zlib))", expected: []string{"libcurl", "pcre"}},
+		{expr: "rdeps(deps(libcurl-dev))", expected: []string{"curl", "git", "libcurl-dev"}},
+	}
+
+	for _, test := range tests {
+		page, err := fixture.Evaluate(test.expr, SearchOptions{})
+		if err != nil {
+			t.Errorf("Unexpected error for expression %q: %v", test.expr, err)
+			continue
+		}
+
+		names := make([]string, len(page.Pkgs))
+		for k, p := range page.Pkgs {
+			names[k] = p.Name
+		}
+		assertNames(t, test.expr, names, test.expected)
+	}
+}
+
+func TestEvaluate_Options(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "openssl"})
+	fixture.Index(&Pkg{Name: "nginx", Deps: []string{"openssl"}, Meta: &Metadata{Labels: map[string]string{"tier": "prod"}}})
+	fixture.Index(&Pkg{Name: "curl", Deps: []string{"openssl"}})
+	fixture.Index(&Pkg{Name: "httpd", Deps: []string{"openssl"}, Meta: &Metadata{Labels: map[string]string{"tier": "prod"}}})
+
+	page, err := fixture.Evaluate("rdeps(openssl)", SearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(page.Pkgs) != 2 || page.Pkgs[0].Name != "curl" || page.Next != "nginx" {
+		t.Errorf("Expected first page to hold curl and httpd, followed by nginx, but got %+v", page)
+	}
+
+	page, err = fixture.Evaluate("rdeps(openssl)", SearchOptions{Limit: 2, Cursor: page.Next})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(page.Pkgs) != 1 || page.Pkgs[0].Name != "nginx" || page.Next != "" {
+		t.Errorf("Expected last page to hold nginx, but got %+v", page)
+	}
+
+	sel, err := ParseSelector("tier=prod")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	page, err = fixture.Evaluate("closure(curl + httpd + nginx)", SearchOptions{Selector: sel})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(page.Pkgs) != 2 || page.Pkgs[0].Name != "httpd" || page.Pkgs[1].Name != "nginx" {
+		t.Errorf("Expected the selected packages to be httpd and nginx, but got %+v", page.Pkgs)
+	}
+}
+
+func TestParseQuery_Malformed(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{"", "deps(nginx", "deps nginx)", "nginx -", "- nginx", "nginx openssl", "parents(nginx)", "deps()", "roots(nginx)", "deps(nginx, openssl)", "lib[", "()", "nginx,"} {
+		if _, err := parseQuery(expr); err == nil || err.Error() != ErrMalformedQuery {
+			t.Errorf("Expected error for expression %q to be %q, but got %v", expr, ErrMalformedQuery, err)
+		}
+	}
+
+	// operators must be surrounded by whitespaces, such that names can hold them
+	q, err := parseQuery("libcurl-dev")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if p, ok := q.(*pattern); !ok || p.name != "libcurl-dev" {
+		t.Errorf("Expected expression to be the name libcurl-dev, but got %+v", q)
+	}
+}
@@ -0,0 +1,464 @@
+package indexer
+
+import (
+	"container/heap"
+	"math/bits"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// chunkSize is the number of nodes allocated at once by a shard. Nodes never move once allocated.
+const chunkSize = 512
+
+// shardBits is the number of bits of the hash of a name that select its shard.
+const shardBits = 6
+
+// shardCount is the number of shards the registry is split into.
+const shardCount = 1 << shardBits
+
+// registry is the main storage of an InMemoryIndexer, designed to stay compact with tens of millions of packages.
+//
+// Every distinct package name is interned once, and referred to by an integer id everywhere else. Each package is stored as a node, addressed by its id, that holds refs to its dependencies instead of their names. Names are looked up in an open-addressing hash table of ids.
+// Every node also counts the indexed packages that depend on it, so that removals don't need to search the whole registry. Once a name is neither indexed nor depended on, its id and its node are freed, and reused by the next name interned.
+// Pkg values are built on demand when the registry is read.
+//
+// The registry is split into shards by the hashes of the names, such that changes to unrelated packages don't contend for the same lock. Each shard interns its own names, and the refs to dependencies combine the index of their shard with their id.
+// The methods of registry don't lock it. The caller must either hold the lock of the indexer exclusively, or hold it shared together with the locks of the shards involved: the shard of a package exclusively to change it, and the shards of its dependencies, whose names make up the package and whose dependents it counts, shared. See lock.
+type registry struct {
+	shards [shardCount]*shard
+}
+
+// shard holds the packages whose names hash to it.
+type shard struct {
+	sync.RWMutex
+
+	// index is the index of the shard in the registry.
+	index int
+
+	// names holds the interned names, indexed by their ids.
+	names []string
+
+	// slots is the hash table of names. Each slot holds an id plus one, or zero if it is empty.
+	slots []uint32
+
+	// chunks holds the nodes, indexed by the ids of their names.
+	chunks []*[chunkSize]node
+
+	// free holds the ids that were freed, to be reused.
+	free []uint32
+
+	// sorted holds the ids of the indexed packages in alphabetical order.
+	sorted nameIndex
+
+	// text indexes the descriptions of the indexed packages.
+	text textIndex
+
+	// size is the number of indexed packages.
+	size int
+}
+
+// node is a package, whose name is interned with the same id. Its dependents are counted whether it is indexed or not.
+type node struct {
+	deps []ref
+	meta *Metadata
+
+	// dependents is changed atomically, as the packages of other shards that depend on the node only hold the lock of its shard shared.
+	dependents atomic.Uint32
+	indexed    bool
+}
+
+// ref refers to an interned name by the index of its shard, in the low shardBits bits, and its id in the shard.
+type ref uint32
+
+func (f ref) shard() int {
+	return int(f & (shardCount - 1))
+}
+
+func (f ref) id() uint32 {
+	return uint32(f >> shardBits)
+}
+
+func newRegistry() *registry {
+	r := &registry{}
+	for k := range r.shards {
+		r.shards[k] = &shard{index: k, slots: make([]uint32, 64)}
+	}
+	return r
+}
+
+// shard returns the shard of name. The hash of name is multiplied by 2^32 divided by the golden ratio, whose high bits depend on all the bits of the hash, as those of FNV-1a alone are poorly spread for similar names. The hash tables of the shards use the low bits of the hash instead.
+func (r *registry) shard(name string) *shard {
+	return r.shards[(hash(name)*2654435769)>>(32-shardBits)]
+}
+
+func (r *registry) lookup(name string) (*Pkg, bool) {
+	s := r.shard(name)
+	id, n := s.find(name)
+	if n == nil || !n.indexed {
+		return nil, false
+	}
+	return r.pkg(s, id, n), true
+}
+
+// has returns true if name is indexed. Unlike lookup, it doesn't build a Pkg.
+func (r *registry) has(name string) bool {
+	_, n := r.shard(name).find(name)
+	return n != nil && n.indexed
+}
+
+func (r *registry) each(fn func(*Pkg) bool) {
+	for _, s := range r.shards {
+		for id := range s.names {
+			n := s.node(uint32(id))
+			if n.indexed && !fn(r.pkg(s, uint32(id), n)) {
+				return
+			}
+		}
+	}
+}
+
+// ascend calls fn for every indexed package whose name isn't less than start, in alphabetical order, until fn returns false. The sorted ids of the shards are merged.
+func (r *registry) ascend(start string, fn func(*Pkg) bool) {
+	h := make(shardCursors, 0, shardCount)
+	for _, s := range r.shards {
+		if c := s.sorted.seek(s.names, start); !c.done() {
+			h = append(h, shardCursor{s: s, c: c})
+		}
+	}
+	heap.Init(&h)
+
+	for len(h) > 0 {
+		s, id := h[0].s, h[0].c.id()
+		if !fn(r.pkg(s, id, s.node(id))) {
+			return
+		}
+
+		if h[0].c.next(); h[0].c.done() {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+}
+
+// dependents returns the number of indexed packages that depend on name.
+func (r *registry) dependents(name string) int {
+	if _, n := r.shard(name).find(name); n != nil && n.indexed {
+		return int(n.dependents.Load())
+	}
+	return 0
+}
+
+// insert indexes p. If p is already indexed, only its metadata is replaced, and the previous version of p is returned.
+func (r *registry) insert(p *Pkg) *Pkg {
+	s := r.shard(p.Name)
+	id, n := s.intern(p.Name)
+	if n.indexed {
+		prev := r.pkg(s, id, n)
+		s.describe(id, n, p.Meta)
+		return prev
+	}
+
+	// the dependencies are interned already, unless the caller holds the registry exclusively
+	n.deps = nil
+	if len(p.Deps) > 0 {
+		n.deps = make([]ref, len(p.Deps))
+		for k, d := range p.Deps {
+			ds := r.shard(d)
+			depID, dep := ds.intern(d)
+			n.deps[k] = ref(depID<<shardBits) | ref(ds.index)
+			dep.dependents.Add(1)
+		}
+	}
+
+	n.indexed = true
+	s.size++
+	s.describe(id, n, p.Meta)
+	s.sorted.insert(s.names, id)
+	return nil
+}
+
+// delete removes name from r, and returns the removed package.
+func (r *registry) delete(name string) *Pkg {
+	s := r.shard(name)
+	id, n := s.find(name)
+	if n == nil || !n.indexed {
+		return nil
+	}
+
+	// the dependencies are indexed, and thus not freed, unless the caller holds the registry exclusively
+	p := r.pkg(s, id, n)
+	for _, d := range n.deps {
+		ds := r.shards[d.shard()]
+		dep := ds.node(d.id())
+		if dep.dependents.Add(^uint32(0)) == 0 && !dep.indexed {
+			ds.release(d.id())
+		}
+	}
+
+	s.describe(id, n, nil)
+	n.deps, n.indexed = nil, false
+	s.size--
+	s.sorted.delete(s.names, id)
+	if n.dependents.Load() == 0 {
+		s.release(id)
+	}
+	return p
+}
+
+// pkg builds the package of node n, interned as id in shard s.
+func (r *registry) pkg(s *shard, id uint32, n *node) *Pkg {
+	p := &Pkg{Name: s.names[id], Meta: n.meta}
+	if len(n.deps) > 0 {
+		p.Deps = make([]string, len(n.deps))
+		for k, d := range n.deps {
+			p.Deps[k] = r.shards[d.shard()].names[d.id()]
+		}
+	}
+	return p
+}
+
+// describedBy returns the indexed packages whose descriptions have all the words.
+func (r *registry) describedBy(words []string) []*Pkg {
+	var pkgs []*Pkg
+	for _, s := range r.shards {
+		for _, id := range s.text.lookup(words) {
+			pkgs = append(pkgs, r.pkg(s, id, s.node(id)))
+		}
+	}
+	return pkgs
+}
+
+func (r *registry) count() int {
+	size := 0
+	for _, s := range r.shards {
+		size += s.size
+	}
+	return size
+}
+
+// lock locks the shard of name, exclusively if write is set, and the shards of deps shared. It returns the locked shards, to be unlocked by unlock.
+// Shards are always locked in the order of their indices, such that the operations that lock overlapping shards don't deadlock.
+func (r *registry) lock(name string, deps []string, write bool) lockedShards {
+	l := lockedShards{own: r.shard(name).index, write: write}
+	for _, d := range deps {
+		l.shared |= 1 << uint(r.shard(d).index)
+	}
+	r.lockShards(l)
+	return l
+}
+
+// lockIndexed locks the shard of name like lock, along with the shards of the dependencies of the package indexed as name, if any.
+// The dependencies are read before the shards are locked, so they are locked again if the package changes in between.
+func (r *registry) lockIndexed(name string, write bool) lockedShards {
+	s := r.shard(name)
+	for {
+		s.RLock()
+		deps := s.depShards(name)
+		s.RUnlock()
+
+		l := lockedShards{own: s.index, write: write, shared: deps}
+		r.lockShards(l)
+		if s.depShards(name)&^deps == 0 {
+			return l
+		}
+		r.unlock(l)
+	}
+}
+
+// lockedShards is a set of locked shards: the shard of a package, and the shards of its dependencies.
+type lockedShards struct {
+	own   int
+	write bool
+
+	// shared has the bits of the indices of the shards locked shared, besides own.
+	shared uint64
+}
+
+func (r *registry) lockShards(l lockedShards) {
+	for all := l.shared | 1<<uint(l.own); all != 0; all &= all - 1 {
+		k := bits.TrailingZeros64(all)
+		if k == l.own && l.write {
+			r.shards[k].Lock()
+		} else {
+			r.shards[k].RLock()
+		}
+	}
+}
+
+func (r *registry) unlock(l lockedShards) {
+	for all := l.shared | 1<<uint(l.own); all != 0; all &= all - 1 {
+		k := bits.TrailingZeros64(all)
+		if k == l.own && l.write {
+			r.shards[k].Unlock()
+		} else {
+			r.shards[k].RUnlock()
+		}
+	}
+}
+
+// rlockAll locks all the shards of r shared, such that r can be read as a whole.
+func (r *registry) rlockAll() {
+	for _, s := range r.shards {
+		s.RLock()
+	}
+}
+
+func (r *registry) runlockAll() {
+	for _, s := range r.shards {
+		s.RUnlock()
+	}
+}
+
+// shardCursors is a heap of positions in the sorted ids of the shards, ordered by the names at the positions.
+type shardCursors []shardCursor
+
+type shardCursor struct {
+	s *shard
+	c nameCursor
+}
+
+func (h shardCursors) Len() int { return len(h) }
+func (h shardCursors) Less(a, b int) bool {
+	return h[a].s.names[h[a].c.id()] < h[b].s.names[h[b].c.id()]
+}
+func (h shardCursors) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *shardCursors) Push(x interface{}) { *h = append(*h, x.(shardCursor)) }
+func (h *shardCursors) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// depShards returns the bits of the indices of the shards of the dependencies of the package indexed as name, if any.
+func (s *shard) depShards(name string) uint64 {
+	var deps uint64
+	if _, n := s.find(name); n != nil && n.indexed {
+		for _, d := range n.deps {
+			deps |= 1 << uint(d.shard())
+		}
+	}
+	return deps
+}
+
+// describe replaces the metadata of node n of package id with meta, and updates the full-text index.
+func (s *shard) describe(id uint32, n *node, meta *Metadata) {
+	if n.meta != nil {
+		s.text.drop(id, n.meta.Description)
+	}
+	if meta != nil {
+		s.text.add(id, meta.Description)
+	}
+	n.meta = meta
+}
+
+// find returns the id and the node of name, or a nil node if name has never been interned.
+func (s *shard) find(name string) (uint32, *node) {
+	id, exist := s.slot(name)
+	if !exist {
+		return 0, nil
+	}
+	return id, s.node(id)
+}
+
+// intern returns the id and the node of name, allocating them if name has never been interned.
+func (s *shard) intern(name string) (uint32, *node) {
+	if id, exist := s.slot(name); exist {
+		return id, s.node(id)
+	}
+
+	// copy name, so that it doesn't pin the message it was parsed from
+	var id uint32
+	if k := len(s.free); k > 0 {
+		id, s.free = s.free[k-1], s.free[:k-1]
+		s.names[id] = strings.Clone(name)
+	} else {
+		id = uint32(len(s.names))
+		s.names = append(s.names, strings.Clone(name))
+		if int(id)%chunkSize == 0 {
+			s.chunks = append(s.chunks, &[chunkSize]node{})
+		}
+	}
+
+	// keep the hash table at most half full
+	if (len(s.names)-len(s.free))*2 > len(s.slots) {
+		s.rehash(len(s.slots) * 2)
+	}
+	s.place(id)
+	return id, s.node(id)
+}
+
+// release frees id, whose name is neither indexed nor depended on, such that it can be reused by another name.
+func (s *shard) release(id uint32) {
+	s.unplace(id)
+	s.names[id] = ""
+	*s.node(id) = node{}
+	s.free = append(s.free, id)
+}
+
+func (s *shard) node(id uint32) *node {
+	return &s.chunks[id/chunkSize][id%chunkSize]
+}
+
+// slot probes the hash table for name.
+func (s *shard) slot(name string) (uint32, bool) {
+	mask := uint32(len(s.slots) - 1)
+	for k := hash(name) & mask; s.slots[k] != 0; k = (k + 1) & mask {
+		if id := s.slots[k] - 1; s.names[id] == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// place inserts id into the hash table.
+func (s *shard) place(id uint32) {
+	mask := uint32(len(s.slots) - 1)
+	k := hash(s.names[id]) & mask
+	for s.slots[k] != 0 {
+		k = (k + 1) & mask
+	}
+	s.slots[k] = id + 1
+}
+
+// unplace removes id from the hash table. The ids probed after it are moved back into the freed slots that they can occupy, such that the probes for them don't stop early.
+func (s *shard) unplace(id uint32) {
+	mask := uint32(len(s.slots) - 1)
+	k := hash(s.names[id]) & mask
+	for s.slots[k] != id+1 {
+		k = (k + 1) & mask
+	}
+	s.slots[k] = 0
+
+	for j := (k + 1) & mask; s.slots[j] != 0; j = (j + 1) & mask {
+		// the id in slot j can move back to slot k, unless its probe starts after k
+		start := hash(s.names[s.slots[j]-1]) & mask
+		if (j-start)&mask >= (j-k)&mask {
+			s.slots[k], s.slots[j] = s.slots[j], 0
+			k = j
+		}
+	}
+}
+
+// rehash grows the hash table to size slots, and places the ids of the current table into it.
+func (s *shard) rehash(size int) {
+	slots := s.slots
+	s.slots = make([]uint32, size)
+	for _, slot := range slots {
+		if slot != 0 {
+			s.place(slot - 1)
+		}
+	}
+}
+
+// hash hashes name with 32-bit FNV-1a.
+func hash(name string) uint32 {
+	h := uint32(2166136261)
+	for k := 0; k < len(name); k++ {
+		h ^= uint32(name[k])
+		h *= 16777619
+	}
+	return h
+}
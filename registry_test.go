@@ -0,0 +1,367 @@
+package indexer
+
+import (
+	"flag"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var benchPackages = flag.Int("bench.packages", 100000, "number of packages indexed by the large registry benchmarks, e.g. 10000000")
+
+func TestRegistry_Dependents(t *testing.T) {
+	r := newRegistry()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	r.insert(pcre)
+	r.insert(zlib)
+	r.insert(&Pkg{Name: "nginx", Deps: []string{pcre.Name, zlib.Name}})
+	r.insert(&Pkg{Name: "haproxy", Deps: []string{pcre.Name}})
+
+	if n := r.dependents(pcre.Name); n != 2 {
+		t.Errorf("Expected %q to have 2 dependents, but got %d", pcre.Name, n)
+	}
+
+	if p := r.delete("nginx"); p == nil || p.Name != "nginx" {
+		t.Errorf("Expected nginx to be deleted, but got %+v", p)
+	}
+	if n := r.dependents(pcre.Name); n != 1 {
+		t.Errorf("Expected %q to have 1 dependent, but got %d", pcre.Name, n)
+	}
+	if n := r.dependents(zlib.Name); n != 0 {
+		t.Errorf("Expected %q to have no dependents, but got %d", zlib.Name, n)
+	}
+
+	if p := r.delete("nginx"); p != nil {
+		t.Errorf("Expected deleting a missing package to return nil, but got %+v", p)
+	}
+	if count := r.count(); count != 3 {
+		t.Errorf("Expected registry to have 3 packages, but got %d", count)
+	}
+}
+
+func TestRegistry_Churn(t *testing.T) {
+	r := newRegistry()
+	r.insert(&Pkg{Name: "libc"})
+
+	// every round indexes new packages, which depend on a package that isn't indexed, and deletes them in random order
+	const size = 1000
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		names := make([]string, size)
+		for k := range names {
+			names[k] = "pkg-" + strconv.Itoa(round) + "-" + strconv.Itoa(k)
+			r.insert(&Pkg{Name: names[k], Deps: []string{"libc", "dep-" + strconv.Itoa(round)}})
+		}
+
+		rnd.Shuffle(size, func(a, b int) {
+			names[a], names[b] = names[b], names[a]
+		})
+		for k, name := range names {
+			r.delete(name)
+			if r.has(name) {
+				t.Fatalf("Expected %q to be deleted", name)
+			}
+
+			// the names that are left are still found, once the slots of the deleted ones are freed
+			if k%100 == 0 {
+				for _, left := range names[k+1:] {
+					if !r.has(left) {
+						t.Fatalf("Expected %q to be found after deleting %q", left, name)
+					}
+				}
+			}
+		}
+	}
+
+	// the ids and the slots of the deleted names are reused, so that the registry doesn't grow
+	ids, slots, live := 0, 0, 0
+	for _, s := range r.shards {
+		ids, slots, live = ids+len(s.names), slots+len(s.slots), live+len(s.names)-len(s.free)
+	}
+	if live != 1 {
+		t.Errorf("Expected only libc to be interned, but got %d names", live)
+	}
+	if ids > 2*size {
+		t.Errorf("Expected at most %d ids, but got %d", 2*size, ids)
+	}
+	if slots > 2*shardCount*64 {
+		t.Errorf("Expected the hash tables to grow at most once, but got %d slots", slots)
+	}
+	if n := r.dependents("libc"); n != 0 {
+		t.Errorf("Expected libc to have no dependents, but got %d", n)
+	}
+}
+
+func TestIndexRemove_ConcurrentDependents(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	libc := &Pkg{Name: "libc"}
+	fixture.Index(libc)
+
+	// clients concurrently index and remove packages sharing a dependency, while others try to remove the dependency
+	const workers = 8
+	w := &sync.WaitGroup{}
+	w.Add(workers * 2)
+	for k := 0; k < workers; k++ {
+		go func(name string) {
+			defer w.Done()
+			for n := 0; n < 500; n++ {
+				fixture.Index(&Pkg{Name: name, Deps: []string{libc.Name}})
+				fixture.Remove(name)
+			}
+			fixture.Index(&Pkg{Name: name, Deps: []string{libc.Name}})
+		}("pkg-" + strconv.Itoa(k))
+
+		go func() {
+			defer w.Done()
+			for n := 0; n < 500; n++ {
+				if fixture.Remove(libc.Name) == OK {
+					fixture.Index(libc)
+				}
+			}
+		}()
+	}
+	w.Wait()
+
+	// the last index of a package fails if the dependency happens to be removed at the time
+	assertConsistent(fixture, t)
+	if fixture.Query(libc.Name) == OK {
+		indexed := 0
+		for k := 0; k < workers; k++ {
+			if fixture.Query("pkg-"+strconv.Itoa(k)) == OK {
+				indexed++
+			}
+		}
+		if n := fixture.registry.dependents(libc.Name); n != indexed {
+			t.Errorf("Expected %q to have %d dependents, but got %d", libc.Name, indexed, n)
+		}
+	}
+}
+
+func TestIndex_ShardLocks(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "libc"})
+	libc := fixture.registry.shard("libc")
+
+	name := "pkg-0"
+	for k := 1; fixture.registry.shard(name) == libc; k++ {
+		name = "pkg-" + strconv.Itoa(k)
+	}
+
+	// while a change holds the shard of libc, the packages of the other shards can still be indexed
+	libc.Lock()
+	unrelated := make(chan string)
+	go func() {
+		unrelated <- fixture.Index(&Pkg{Name: name})
+	}()
+	select {
+	case res := <-unrelated:
+		if res != OK {
+			t.Errorf("Expected response to be %q, but got %q", OK, res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected %s to be indexed while the shard of libc is locked", name)
+	}
+
+	// the dependents of libc wait for its shard, as they count on libc
+	dependent := make(chan string)
+	go func() {
+		dependent <- fixture.Index(&Pkg{Name: name + "-dev", Deps: []string{"libc"}})
+	}()
+	select {
+	case res := <-dependent:
+		t.Fatalf("Expected a dependent of libc to wait for its shard, but got %q", res)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	libc.Unlock()
+	if res := <-dependent; res != OK {
+		t.Errorf("Expected response to be %q, but got %q", OK, res)
+	}
+}
+
+func TestIndexRemove_ConcurrentShards(t *testing.T) {
+	t.Parallel()
+
+	// clients concurrently change packages that depend on each other across shards, while others read them
+	fixture := NewInMemoryIndexer()
+	const workers, names = 8, 50
+	w := &sync.WaitGroup{}
+	w.Add(workers)
+	for k := 0; k < workers; k++ {
+		go func(rnd *rand.Rand) {
+			defer w.Done()
+			for n := 0; n < 1000; n++ {
+				name := "pkg-" + strconv.Itoa(rnd.Intn(names))
+				switch rnd.Intn(5) {
+				case 0, 1:
+					p := &Pkg{Name: name}
+					for d := rnd.Intn(3); d > 0; d-- {
+						p.Deps = appendDep(p.Deps, "pkg-"+strconv.Itoa(rnd.Intn(names)))
+					}
+					fixture.Index(p)
+				case 2:
+					fixture.Remove(name)
+				case 3:
+					fixture.Annotate(name, &Metadata{Version: strconv.Itoa(n)})
+				case 4:
+					fixture.Describe(name)
+					fixture.List(ListOptions{Limit: 10})
+				}
+			}
+		}(rand.New(rand.NewSource(int64(k))))
+	}
+	w.Wait()
+
+	assertConsistent(fixture, t)
+	fixture.Walk(func(p *Pkg) bool {
+		if n, scanned := fixture.registry.dependents(p.Name), len(dependentsOf(fixture.registry, p.Name)); n != scanned {
+			t.Errorf("Expected %s to have %d dependents, but got %d", p.Name, scanned, n)
+		}
+		return true
+	})
+
+	// the changes are journaled in an order that satisfies the dependencies constraints, and replaying them rebuilds the registry
+	changes, err := fixture.Changes(0)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	replayed := NewInMemoryIndexer()
+	for _, c := range changes {
+		var res string
+		switch c.Op {
+		case opIndex:
+			res = replayed.Index(c.Pkg)
+		case opRemove:
+			res = replayed.Remove(c.Pkg.Name)
+		case opAnnotate:
+			res = replayed.Annotate(c.Pkg.Name, c.Pkg.Meta)
+		}
+		if res != OK {
+			t.Fatalf("Expected change %d to be replayed, but got %q", c.Rev, res)
+		}
+	}
+	if d := Compare(fixture, replayed); !d.Empty() {
+		t.Errorf("Expected replayed registry to be the same, but got %+v", d)
+	}
+}
+
+func BenchmarkIndexRemove_Parallel(b *testing.B) {
+	fixture := benchmarkFixture(1000)
+	var id int64
+	var m sync.Mutex
+	b.ResetTimer()
+
+	// every goroutine works on its own packages, which depend on shared packages
+	b.RunParallel(func(pb *testing.PB) {
+		m.Lock()
+		id++
+		prefix := "client-" + strconv.FormatInt(id, 10) + "-"
+		m.Unlock()
+
+		n := 0
+		for pb.Next() {
+			name := prefix + strconv.Itoa(n%100)
+			if n%2 == 0 {
+				fixture.Index(&Pkg{Name: name, Deps: []string{"pkg-" + strconv.Itoa(n%1000)}})
+			} else {
+				fixture.Remove(name)
+			}
+			n++
+		}
+	})
+}
+
+// BenchmarkIndex_Parallel measures the throughput of writes to unrelated packages. They only lock the shards of their packages, and hold the lock of the journal briefly, so the time per operation drops with -cpu 1,2,4,8 as long as there are as many cores.
+func BenchmarkIndex_Parallel(b *testing.B) {
+	fixture := NewInMemoryIndexer()
+	var id int64
+	var m sync.Mutex
+	b.ResetTimer()
+
+	// every goroutine indexes its own new packages, without dependencies
+	b.RunParallel(func(pb *testing.PB) {
+		m.Lock()
+		id++
+		prefix := "client-" + strconv.FormatInt(id, 10) + "-"
+		m.Unlock()
+
+		n := 0
+		for pb.Next() {
+			fixture.Index(&Pkg{Name: prefix + strconv.Itoa(n)})
+			n++
+		}
+	})
+}
+
+func BenchmarkLarge_Registry(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		var i *InMemoryIndexer
+		benchmarkLarge(b, func() {
+			i = NewInMemoryIndexer()
+		}, func(p *Pkg) {
+			i.Index(p)
+		}, func(name string) {
+			i.Query(name)
+		})
+		runtime.KeepAlive(i)
+	}
+}
+
+// BenchmarkLarge_PkgMap measures the layout of the registry in 1.0.0, where every Pkg is kept as is in a map, for comparison.
+func BenchmarkLarge_PkgMap(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		var m map[string]*Pkg
+		benchmarkLarge(b, func() {
+			m = map[string]*Pkg{}
+		}, func(p *Pkg) {
+			m[p.Name] = p
+		}, func(name string) {
+			_ = m[name]
+		})
+		runtime.KeepAlive(m)
+	}
+}
+
+// benchmarkLarge indexes -bench.packages packages, each depending on up to 3 popular packages, and then queries all of them.
+// It reports the heap size, and the index and query times per package.
+func benchmarkLarge(b *testing.B, setup func(), index func(*Pkg), query func(string)) {
+	total := *benchPackages
+	r := rand.New(rand.NewSource(1))
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	setup()
+
+	start := time.Now()
+	for k := 0; k < total; k++ {
+		// names are built from scratch, like parsed messages are
+		p := &Pkg{Name: "pkg-" + strconv.Itoa(k)}
+		for d := r.Intn(4); d > 0 && k > 0; d-- {
+			p.Deps = append(p.Deps, "pkg-"+strconv.Itoa(r.Intn(min(k, 1000))))
+		}
+		index(p)
+	}
+	indexed := time.Since(start)
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	start = time.Now()
+	for k := 0; k < total; k++ {
+		query("pkg-" + strconv.Itoa(k))
+	}
+	queried := time.Since(start)
+
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(total), "heap-B/pkg")
+	b.ReportMetric(float64(indexed.Nanoseconds())/float64(total), "index-ns/pkg")
+	b.ReportMetric(float64(queried.Nanoseconds())/float64(total), "query-ns/pkg")
+}
@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rpmPackage is the shape of a package element of a repodata primary.xml file. The elements match regardless of their namespaces.
+type rpmPackage struct {
+	Name    string `xml:"name"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Summary  string `xml:"summary"`
+	Packager string `xml:"packager"`
+	URL      string `xml:"url"`
+	Size     struct {
+		Installed int64 `xml:"installed,attr"`
+	} `xml:"size"`
+	Format struct {
+		License  string     `xml:"license"`
+		Provides []rpmEntry `xml:"provides>entry"`
+		Requires []rpmEntry `xml:"requires>entry"`
+		Files    []string   `xml:"file"`
+	} `xml:"format"`
+}
+
+type rpmEntry struct {
+	Name string `xml:"name,attr"`
+}
+
+func init() {
+	RegisterImporter(FormatRPM, ImporterFunc(readRPM))
+}
+
+// ImportRPM reads the repodata primary.xml file of an RPM repository from r, either plain or gzipped, and indexes a package per package element into dst, in dependency order.
+// The requirements of a package are resolved to the packages that provide them: the package of the same name, in r or already indexed in dst, or else the first package whose rpm:provides entries or files hold the requirement. The dependencies of a package are the providers of its requirements, regardless of their versions, except itself. The rpmlib requirements are fulfilled by rpm itself, and are ignored.
+// The version, the summary, the license, the packager, the URL and the installed size of the packages are their metadata. Only the first package of every name is imported, regardless of its architecture.
+// It returns an error if r can't be read, or isn't well-formed XML. Otherwise, the report lists the packages whose requirements have no provider.
+func ImportRPM(r io.Reader, dst Indexer) (*ImportReport, error) {
+	return Import(r, dst, FormatRPM, LoadOptions{})
+}
+
+// readRPM reads the packages of ImportRPM from r.
+func readRPM(r io.Reader, dst Indexer) ([]*Pkg, error) {
+	b := bufio.NewReader(r)
+	if magic, err := b.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(b)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = b
+	}
+
+	var packages []*rpmPackage
+	names := map[string]bool{}
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*xml.SyntaxError); ok {
+				return nil, fmt.Errorf(ErrMalformedImport)
+			}
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "package" {
+			continue
+		}
+
+		p := &rpmPackage{}
+		if err := dec.DecodeElement(p, &start); err != nil {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+		if p.Name == "" {
+			return nil, fmt.Errorf(ErrMalformedImport)
+		}
+		if !names[p.Name] {
+			names[p.Name] = true
+			packages = append(packages, p)
+		}
+	}
+
+	// the first provider of every capability and file
+	providers := map[string]string{}
+	for _, p := range packages {
+		for _, e := range p.Format.Provides {
+			if _, exist := providers[e.Name]; !exist {
+				providers[e.Name] = p.Name
+			}
+		}
+		for _, f := range p.Format.Files {
+			if _, exist := providers[f]; !exist {
+				providers[f] = p.Name
+			}
+		}
+	}
+
+	pkgs := make([]*Pkg, 0, len(packages))
+	for _, rp := range packages {
+		p := &Pkg{Name: rp.Name, Meta: rp.meta()}
+		for _, req := range rp.Format.Requires {
+			if strings.HasPrefix(req.Name, "rpmlib(") {
+				continue
+			}
+
+			d := req.Name
+			if provider, exist := providers[d]; exist && !names[d] && dst.Query(d) != OK {
+				d = provider
+			}
+			if d != p.Name {
+				p.Deps = appendDep(p.Deps, d)
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+func (p *rpmPackage) meta() *Metadata {
+	version := p.Version.Ver
+	if p.Version.Rel != "" {
+		version += "-" + p.Version.Rel
+	}
+	if p.Version.Epoch != "" && p.Version.Epoch != "0" {
+		version = p.Version.Epoch + ":" + version
+	}
+
+	m := &Metadata{
+		Description: strings.TrimSpace(p.Summary),
+		Version:     version,
+		License:     p.Format.License,
+		Maintainer:  p.Packager,
+		Homepage:    p.URL,
+		Size:        p.Size.Installed,
+	}
+	return m.clone()
+}
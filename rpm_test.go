@@ -0,0 +1,83 @@
+package indexer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestImportRPM(t *testing.T) {
+	t.Parallel()
+
+	data, err := ioutil.ReadFile("testdata/primary.xml")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(data)
+	gz.Close()
+
+	for _, r := range []*bytes.Reader{bytes.NewReader(data), bytes.NewReader(gzipped.Bytes())} {
+		fixture := NewInMemoryIndexer()
+		report, err := ImportRPM(r, fixture)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		assertNames(t, "indexed", report.Indexed, []string{"filesystem", "glibc", "ncurses-libs", "bash"})
+		assertNames(t, "unmet dependencies of coreutils", report.Unmet["coreutils"], []string{"libacl.so.1()(64bit)"})
+
+		// requirements are resolved to the packages that provide them, as capabilities or as files
+		assertNames(t, "dependencies of bash", fixture.Describe("bash").Deps, []string{"filesystem", "glibc", "ncurses-libs"})
+		assertNames(t, "dependencies of glibc", fixture.Describe("glibc").Deps, []string{"filesystem"})
+
+		bash := fixture.Describe("bash")
+		if bash.Meta.Version != "5.1.8-6.el9" || bash.Meta.License != "GPLv3+" || bash.Meta.Size != 7738634 || bash.Meta.Homepage != "https://www.gnu.org/software/bash" {
+			t.Errorf("Expected metadata of the x86_64 bash, but got %+v", bash.Meta)
+		}
+	}
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "libacl.so.1()(64bit)"})
+	report, err := ImportRPM(bytes.NewReader(data), fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if p := fixture.Describe("coreutils"); len(report.Unmet) != 0 || p == nil || p.Meta.Version != "1:8.32-34.el9" {
+		t.Errorf("Expected coreutils to be indexed, but got %+v", report)
+	}
+}
+
+func TestImportRPM_Indexed(t *testing.T) {
+	t.Parallel()
+
+	data, err := ioutil.ReadFile("testdata/primary.xml")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// a requirement that is already indexed by name is resolved to the indexed package, rather than to the package of r that provides it
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "libtinfo.so.6()(64bit)"})
+	report, err := ImportRPM(bytes.NewReader(data), fixture)
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	assertNames(t, "indexed", report.Indexed, []string{"filesystem", "glibc", "bash", "ncurses-libs"})
+	assertNames(t, "dependencies of bash", fixture.Describe("bash").Deps, []string{"filesystem", "glibc", "libtinfo.so.6()(64bit)"})
+}
+
+func TestImportRPM_Malformed(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"<metadata><package><name>bash</name>", "<metadata><package><arch>x86_64</arch></package></metadata>", "<metadata><package><name>bash</nam></package></metadata>"} {
+		if _, err := ImportRPM(strings.NewReader(data), NewInMemoryIndexer()); err == nil || err.Error() != ErrMalformedImport {
+			t.Errorf("Expected error for %q to be %q, but got %v", data, ErrMalformedImport, err)
+		}
+	}
+}
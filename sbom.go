@@ -0,0 +1,393 @@
+package indexer
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The formats of WriteSBOM.
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+func init() {
+	for _, format := range []string{FormatCycloneDX, FormatSPDX} {
+		format := format
+		RegisterExporter(format, ExporterFunc(func(w io.Writer, src Walker) error {
+			return WriteSBOM(w, src, format, SBOMOptions{})
+		}))
+	}
+}
+
+// sbomTool is the name of the tool that creates the SBOMs.
+const sbomTool = "indexer"
+
+// SBOMOptions selects the packages of the SBOM written by WriteSBOM.
+type SBOMOptions struct {
+	// Root restricts the SBOM to the closure of the package Root, i.e. Root along with all its dependencies. Root is then the subject of the SBOM. An empty Root covers all the packages.
+	Root string
+
+	// Created is the creation time of the SBOM. A zero Created is the current time.
+	Created time.Time
+}
+
+// WriteSBOM writes a software bill of materials of the packages of src to w, as a CycloneDX 1.5 JSON or an SPDX 2.3 JSON document, according to format. The components of the SBOM are taken from the names, the dependencies, and the version, license, description and homepage metadata of the packages.
+// The licenses of SPDX documents that aren't SPDX license expressions are declared by LicenseRef identifiers, along with their texts.
+// It returns an error if format is unknown, or if opts.Root isn't indexed in src.
+func WriteSBOM(w io.Writer, src Walker, format string, opts SBOMOptions) error {
+	if format != FormatCycloneDX && format != FormatSPDX {
+		return fmt.Errorf(ErrUnknownFormat)
+	}
+
+	var pkgs []*Pkg
+	src.Walk(func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+
+	if opts.Root != "" {
+		var err error
+		if pkgs, err = closure(pkgs, opts.Root, 0); err != nil {
+			return err
+		}
+	}
+
+	if opts.Created.IsZero() {
+		opts.Created = time.Now()
+	}
+	opts.Created = opts.Created.UTC().Truncate(time.Second)
+
+	var doc interface{}
+	if format == FormatCycloneDX {
+		doc = cycloneDX(pkgs, opts)
+	} else {
+		doc = spdx(pkgs, opts)
+	}
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string        `json:"timestamp"`
+	Tools     cdxTools      `json:"tools"`
+	Component *cdxComponent `json:"component,omitempty"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type        string       `json:"type"`
+	BOMRef      string       `json:"bom-ref,omitempty"`
+	Name        string       `json:"name"`
+	Version     string       `json:"version,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Licenses    []cdxLicense `json:"licenses,omitempty"`
+	References  []cdxRef     `json:"externalReferences,omitempty"`
+}
+
+type cdxLicense struct {
+	License    *cdxLicenseName `json:"license,omitempty"`
+	Expression string          `json:"expression,omitempty"`
+}
+
+type cdxLicenseName struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type cdxRef struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// cycloneDX returns a CycloneDX BOM of pkgs, whose components are referred to by the package names. The root package of opts is the component described by the BOM, rather than one of its components.
+func cycloneDX(pkgs []*Pkg, opts SBOMOptions) *cdxBOM {
+	bom := &cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: opts.Created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: sbomTool}}},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+
+	for _, p := range pkgs {
+		c := cdxComponent{Type: "library", BOMRef: p.Name, Name: p.Name}
+		if p.Meta != nil {
+			c.Version, c.Description = p.Meta.Version, p.Meta.Description
+			if p.Meta.License != "" {
+				c.Licenses = []cdxLicense{cdxLicenseChoice(p.Meta.License)}
+			}
+			if p.Meta.Homepage != "" {
+				c.References = []cdxRef{{Type: "website", URL: p.Meta.Homepage}}
+			}
+		}
+
+		if p.Name == opts.Root {
+			bom.Metadata.Component = &c
+		} else {
+			bom.Components = append(bom.Components, c)
+		}
+		bom.Dependencies = append(bom.Dependencies, cdxDependency{Ref: p.Name, DependsOn: append([]string{}, p.Deps...)})
+	}
+	return bom
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+	Licenses          []spdxLicense      `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+	LicenseConcluded string `json:"licenseConcluded"`
+	LicenseDeclared  string `json:"licenseDeclared"`
+	CopyrightText    string `json:"copyrightText"`
+	Description      string `json:"description,omitempty"`
+	Homepage         string `json:"homepage,omitempty"`
+}
+
+type spdxLicense struct {
+	LicenseID     string `json:"licenseId"`
+	ExtractedText string `json:"extractedText"`
+	Name          string `json:"name"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// cdxLicenseChoice returns the CycloneDX license of the declared license l: the id of a license of the SPDX license list, an SPDX license expression, or else the name of the license.
+func cdxLicenseChoice(l string) cdxLicense {
+	switch {
+	case spdxListed(l):
+		return cdxLicense{License: &cdxLicenseName{ID: l}}
+	case spdxExpression(l):
+		return cdxLicense{Expression: l}
+	}
+	return cdxLicense{License: &cdxLicenseName{Name: l}}
+}
+
+// spdxNoAssertion is the SPDX value of the fields whose values aren't known.
+const spdxNoAssertion = "NOASSERTION"
+
+// spdx returns an SPDX document of pkgs. The document describes the root package of opts, or the packages that no other package of pkgs depends on.
+func spdx(pkgs []*Pkg, opts SBOMOptions) *spdxDocument {
+	name := opts.Root
+	if name == "" {
+		name = sbomTool
+	}
+
+	// the namespace is unique to the subject, the time and the packages of the document
+	h := sha1.New()
+	fmt.Fprintln(h, opts.Root, opts.Created.Unix())
+	for _, p := range pkgs {
+		fmt.Fprintln(h, FormatMsg(opIndex, p))
+	}
+
+	doc := &spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://github.com/ihcsim/indexer/spdx/%s-%x", spdxIDString(name), h.Sum(nil)),
+		CreationInfo: spdxCreationInfo{
+			Created:  opts.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + sbomTool},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	ids := spdxIDs(pkgs)
+	refs := map[string]string{}
+	depended := map[string]bool{}
+	for _, p := range pkgs {
+		sp := spdxPackage{
+			Name:             p.Name,
+			SPDXID:           ids[p.Name],
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+		}
+		if p.Meta != nil {
+			sp.VersionInfo, sp.Description, sp.Homepage = p.Meta.Version, p.Meta.Description, p.Meta.Homepage
+			if p.Meta.License != "" {
+				sp.LicenseDeclared = doc.license(p.Meta.License, refs)
+			}
+		}
+		doc.Packages = append(doc.Packages, sp)
+
+		for _, d := range p.Deps {
+			depended[d] = true
+			doc.Relationships = append(doc.Relationships, spdxRelationship{Element: ids[p.Name], Type: "DEPENDS_ON", Related: ids[d]})
+		}
+	}
+
+	var described []spdxRelationship
+	for _, p := range pkgs {
+		if p.Name == opts.Root || (opts.Root == "" && !depended[p.Name]) {
+			described = append(described, spdxRelationship{Element: doc.SPDXID, Type: "DESCRIBES", Related: ids[p.Name]})
+		}
+	}
+	doc.Relationships = append(described, doc.Relationships...)
+	return doc
+}
+
+// license returns the SPDX license expression of the declared license l. A license that isn't an SPDX license expression, like "GPLv2 or later", is referred to by a LicenseRef identifier, and its text is added to the extracted licenses of doc. refs holds the identifiers of the licenses already extracted, and the texts of those identifiers.
+// The LicenseRef identifiers that l uses are extracted too, with l as their text, as the document must declare every LicenseRef identifier it uses.
+func (doc *spdxDocument) license(l string, refs map[string]string) string {
+	if spdxExpression(l) {
+		for _, token := range spdxTokens(l) {
+			if strings.HasPrefix(token, "LicenseRef-") && refs[token] == "" {
+				refs[token] = l
+				doc.Licenses = append(doc.Licenses, spdxLicense{LicenseID: token, ExtractedText: l, Name: strings.TrimPrefix(token, "LicenseRef-")})
+			}
+		}
+		return l
+	}
+	if ref, exist := refs[l]; exist {
+		return ref
+	}
+
+	// the licenses whose texts only differ by the characters that identifiers can't hold are told apart by a numeric suffix
+	ref := "LicenseRef-" + spdxIDString(l)
+	for n := 2; refs[ref] != ""; n++ {
+		ref = "LicenseRef-" + spdxIDString(l) + "-" + strconv.Itoa(n)
+	}
+	refs[l], refs[ref] = ref, l
+	doc.Licenses = append(doc.Licenses, spdxLicense{LicenseID: ref, ExtractedText: l, Name: l})
+	return ref
+}
+
+// spdxLicenseID matches the license identifiers of SPDX license expressions, either an SPDX license identifier, possibly followed by "+", or a LicenseRef identifier.
+var spdxLicenseID = regexp.MustCompile(`^((DocumentRef-[A-Za-z0-9.-]+:)?LicenseRef-[A-Za-z0-9.-]+|[A-Za-z0-9.-]+\+?)$`)
+
+// spdxExpression reports whether l is an SPDX license expression, e.g. "MIT", "(MIT OR Apache-2.0) AND BSD-3-Clause" or "GPL-2.0-or-later WITH Classpath-exception-2.0". Only the syntax of l is checked, not whether its identifiers are on the SPDX license list.
+func spdxExpression(l string) bool {
+	rest, ok := spdxCompound(spdxTokens(l))
+	return ok && len(rest) == 0
+}
+
+// spdxTokens splits the license expression l into its identifiers, operators and parentheses.
+func spdxTokens(l string) []string {
+	return strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(l))
+}
+
+// spdxCompound parses the compound expression at the start of tokens, and returns the tokens that follow it.
+func spdxCompound(tokens []string) ([]string, bool) {
+	for {
+		var ok bool
+		if tokens, ok = spdxSimple(tokens); !ok {
+			return nil, false
+		}
+		if len(tokens) == 0 || (tokens[0] != "AND" && tokens[0] != "OR") {
+			return tokens, true
+		}
+		tokens = tokens[1:]
+	}
+}
+
+// spdxSimple parses the license, the license with an exception, or the parenthesized expression at the start of tokens, and returns the tokens that follow it.
+func spdxSimple(tokens []string) ([]string, bool) {
+	if len(tokens) == 0 {
+		return nil, false
+	}
+	if tokens[0] == "(" {
+		rest, ok := spdxCompound(tokens[1:])
+		if !ok || len(rest) == 0 || rest[0] != ")" {
+			return nil, false
+		}
+		return rest[1:], true
+	}
+
+	if !spdxOperand(tokens[0]) {
+		return nil, false
+	}
+	tokens = tokens[1:]
+	if len(tokens) > 0 && tokens[0] == "WITH" {
+		if len(tokens) == 1 || !spdxOperand(tokens[1]) || strings.HasSuffix(tokens[1], "+") {
+			return nil, false
+		}
+		tokens = tokens[2:]
+	}
+	return tokens, true
+}
+
+func spdxOperand(token string) bool {
+	return token != "AND" && token != "OR" && token != "WITH" && spdxLicenseID.MatchString(token)
+}
+
+// spdxIDs returns unique SPDX identifiers for the packages of pkgs. Identifiers can only hold letters, digits, dots and dashes, so the packages whose names only differ by other characters are told apart by a numeric suffix.
+func spdxIDs(pkgs []*Pkg) map[string]string {
+	ids := make(map[string]string, len(pkgs))
+	taken := make(map[string]bool, len(pkgs))
+	for _, p := range pkgs {
+		id := "SPDXRef-Package-" + spdxIDString(p.Name)
+		for n := 2; taken[id]; n++ {
+			id = "SPDXRef-Package-" + spdxIDString(p.Name) + "-" + strconv.Itoa(n)
+		}
+		ids[p.Name], taken[id] = id, true
+	}
+	return ids
+}
+
+// spdxIDString replaces the characters of s that SPDX identifiers can't hold with dashes.
+func spdxIDString(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, s)
+}
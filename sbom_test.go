@@ -0,0 +1,503 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sbomSchemas are the JSON schemas of the SBOM formats, in testdata/schema.
+var sbomSchemas = map[string]string{
+	FormatCycloneDX: "bom-1.5.schema.json",
+	FormatSPDX:      "spdx-schema-2.3.json",
+}
+
+// validateSchema returns the violations of the JSON schema in testdata/schema/file by the JSON document doc.
+// It checks the draft-07 keywords used by the schemas of the SBOM formats. The formats of strings are annotations, so they aren't checked.
+func validateSchema(file string, doc []byte) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, err
+	}
+
+	s := &schemaValidator{files: map[string]interface{}{}}
+	root, err := s.load(file)
+	if err != nil {
+		return nil, err
+	}
+	s.validate(root, root, v, "")
+	return s.violations, s.err
+}
+
+type schemaValidator struct {
+	files      map[string]interface{}
+	violations []string
+	err        error
+}
+
+func (s *schemaValidator) load(file string) (interface{}, error) {
+	if schema, exist := s.files[file]; exist {
+		return schema, nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "schema", file))
+	if err != nil {
+		return nil, err
+	}
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	s.files[file] = schema
+	return schema, nil
+}
+
+// resolve returns the schema referred to by ref, either in another file or in root, and the root of its file.
+func (s *schemaValidator) resolve(root interface{}, ref string) (interface{}, interface{}, error) {
+	file, pointer := ref, ""
+	if k := strings.Index(ref, "#"); k >= 0 {
+		file, pointer = ref[:k], ref[k+1:]
+	}
+	if file != "" {
+		var err error
+		if root, err = s.load(file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	schema := root
+	for _, key := range strings.Split(pointer, "/")[1:] {
+		obj, ok := schema.(map[string]interface{})
+		if !ok || obj[key] == nil {
+			return nil, nil, fmt.Errorf("unknown reference %q", ref)
+		}
+		schema = obj[key]
+	}
+	return schema, root, nil
+}
+
+// valid reports whether v is valid against schema, without recording its violations.
+func (s *schemaValidator) valid(root, schema, v interface{}) bool {
+	violations := s.violations
+	s.violations = nil
+	s.validate(root, schema, v, "")
+	ok := len(s.violations) == 0
+	s.violations = violations
+	return ok
+}
+
+func (s *schemaValidator) violate(path, format string, args ...interface{}) {
+	s.violations = append(s.violations, "/"+strings.TrimPrefix(path, "/")+": "+fmt.Sprintf(format, args...))
+}
+
+func (s *schemaValidator) validate(root, schema, v interface{}, path string) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			s.violate(path, "not allowed")
+		}
+		return
+	}
+	sch := schema.(map[string]interface{})
+
+	if ref, ok := sch["$ref"].(string); ok {
+		target, targetRoot, err := s.resolve(root, ref)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.validate(targetRoot, target, v, path)
+		return
+	}
+
+	if t, exist := sch["type"]; exist && !schemaTypes(t, v) {
+		s.violate(path, "expected type %v", t)
+		return
+	}
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			s.violate(path, "%v isn't one of the enumerated values", v)
+		}
+	}
+	if c, exist := sch["const"]; exist && !reflect.DeepEqual(c, v) {
+		s.violate(path, "expected %v", c)
+	}
+
+	for _, sub := range schemaList(sch["allOf"]) {
+		s.validate(root, sub, v, path)
+	}
+	if anyOf := schemaList(sch["anyOf"]); anyOf != nil {
+		n := 0
+		for _, sub := range anyOf {
+			if s.valid(root, sub, v) {
+				n++
+			}
+		}
+		if n == 0 {
+			s.violate(path, "doesn't match any schema of anyOf")
+		}
+	}
+	if oneOf := schemaList(sch["oneOf"]); oneOf != nil {
+		n := 0
+		for _, sub := range oneOf {
+			if s.valid(root, sub, v) {
+				n++
+			}
+		}
+		if n != 1 {
+			s.violate(path, "matches %d schemas of oneOf", n)
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range schemaList(sch["required"]) {
+			if _, exist := v[name.(string)]; !exist {
+				s.violate(path, "missing property %q", name)
+			}
+		}
+		props, _ := sch["properties"].(map[string]interface{})
+		for name, value := range v {
+			if sub, exist := props[name]; exist {
+				s.validate(root, sub, value, path+"/"+name)
+			} else if additional, exist := sch["additionalProperties"]; exist {
+				s.validate(root, additional, value, path+"/"+name)
+			}
+		}
+
+	case []interface{}:
+		if min, ok := sch["minItems"].(float64); ok && float64(len(v)) < min {
+			s.violate(path, "expected at least %v items", min)
+		}
+		if max, ok := sch["maxItems"].(float64); ok && float64(len(v)) > max {
+			s.violate(path, "expected at most %v items", max)
+		}
+		if unique, _ := sch["uniqueItems"].(bool); unique {
+			for a := range v {
+				for b := a + 1; b < len(v); b++ {
+					if reflect.DeepEqual(v[a], v[b]) {
+						s.violate(path, "items %d and %d are equal", a, b)
+					}
+				}
+			}
+		}
+		for k, item := range v {
+			items, exist := sch["items"]
+			if tuple, ok := items.([]interface{}); ok {
+				items, exist = sch["additionalItems"]
+				if k < len(tuple) {
+					items, exist = tuple[k], true
+				}
+			}
+			if exist {
+				s.validate(root, items, item, fmt.Sprintf("%s/%d", path, k))
+			}
+		}
+
+	case string:
+		if min, ok := sch["minLength"].(float64); ok && float64(len([]rune(v))) < min {
+			s.violate(path, "expected at least %v characters", min)
+		}
+		if max, ok := sch["maxLength"].(float64); ok && float64(len([]rune(v))) > max {
+			s.violate(path, "expected at most %v characters", max)
+		}
+		if pattern, ok := sch["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				s.err = err
+			} else if !re.MatchString(v) {
+				s.violate(path, "%q doesn't match %q", v, pattern)
+			}
+		}
+
+	case float64:
+		if min, ok := sch["minimum"].(float64); ok && v < min {
+			s.violate(path, "expected at least %v", min)
+		}
+		if max, ok := sch["maximum"].(float64); ok && v > max {
+			s.violate(path, "expected at most %v", max)
+		}
+	}
+}
+
+func schemaList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+// schemaTypes reports whether v is of the JSON type t, or of one of the types of t.
+func schemaTypes(t, v interface{}) bool {
+	if types, ok := t.([]interface{}); ok {
+		for _, t := range types {
+			if schemaTypes(t, v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	}
+	return false
+}
+
+func TestWriteSBOM(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture,
+		&Pkg{Name: "zlib", Meta: &Metadata{Version: "1.2.13", License: "Zlib", Homepage: "https://zlib.net"}},
+		&Pkg{Name: "openssl", Meta: &Metadata{Version: "3.0.8", License: "Apache-2.0", Description: "TLS toolkit"}},
+		&Pkg{Name: "curl", Deps: []string{"openssl", "zlib"}, Meta: &Metadata{Version: "8.0.1", License: "curl"}},
+		&Pkg{Name: "git", Deps: []string{"curl"}},
+		&Pkg{Name: "jq", Meta: &Metadata{Version: "1.6"}},
+	)
+	created := time.Date(2023, time.March, 20, 9, 30, 0, 0, time.UTC)
+
+	var tests = []struct {
+		format  string
+		opts    SBOMOptions
+		fixture string
+	}{
+		{format: FormatCycloneDX, opts: SBOMOptions{Root: "curl", Created: created}, fixture: "sbom-curl.cdx.json"},
+		{format: FormatCycloneDX, opts: SBOMOptions{Created: created}, fixture: "sbom.cdx.json"},
+		{format: FormatSPDX, opts: SBOMOptions{Root: "curl", Created: created}, fixture: "sbom-curl.spdx.json"},
+		{format: FormatSPDX, opts: SBOMOptions{Created: created}, fixture: "sbom.spdx.json"},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := WriteSBOM(&buf, fixture, test.format, test.opts); err != nil {
+			t.Errorf("Unexpected error for fixture %s: %v", test.fixture, err)
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(filepath.Join("testdata", test.fixture))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if buf.String() != string(expected) {
+			t.Errorf("Expected SBOM to match fixture %s, but got:\n%s", test.fixture, buf.String())
+		}
+
+		violations, err := validateSchema(sbomSchemas[test.format], buf.Bytes())
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if len(violations) != 0 {
+			t.Errorf("Expected SBOM of fixture %s to be valid, but got violations %v", test.fixture, violations)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSBOM(&buf, fixture, FormatDOT, SBOMOptions{}); err == nil || err.Error() != ErrUnknownFormat {
+		t.Errorf("Expected error to be %q, but got %v", ErrUnknownFormat, err)
+	}
+	if err := WriteSBOM(&buf, fixture, FormatSPDX, SBOMOptions{Root: "wget"}); err == nil || err.Error() != ErrNotIndexed {
+		t.Errorf("Expected error to be %q, but got %v", ErrNotIndexed, err)
+	}
+}
+
+func TestWriteSBOM_SPDXIDs(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture, &Pkg{Name: "libstdc++"}, &Pkg{Name: "libstdc__"}, &Pkg{Name: "g++", Deps: []string{"libstdc++", "libstdc__"}})
+
+	var buf bytes.Buffer
+	if err := WriteSBOM(&buf, fixture, FormatSPDX, SBOMOptions{}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	var doc spdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	ids := make([]string, len(doc.Packages))
+	for k, p := range doc.Packages {
+		ids[k] = p.SPDXID
+	}
+	assertNames(t, "SPDX ids", ids, []string{"SPDXRef-Package-g--", "SPDXRef-Package-libstdc--", "SPDXRef-Package-libstdc---2"})
+	if doc.CreationInfo.Created == "" || len(doc.Relationships) != 3 || doc.Relationships[0].Related != "SPDXRef-Package-g--" {
+		t.Errorf("Expected g++ to be described by the document, but got %+v", doc.Relationships)
+	}
+}
+
+func TestWriteSBOM_SPDXLicenses(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture,
+		&Pkg{Name: "bash", Meta: &Metadata{License: "GPL-3.0-or-later"}},
+		&Pkg{Name: "libgcc", Meta: &Metadata{License: "GPL-3.0-or-later WITH GCC-exception-3.1"}},
+		&Pkg{Name: "openssl", Meta: &Metadata{License: "(Apache-2.0 OR MIT) AND BSD-3-Clause"}},
+		&Pkg{Name: "sudo", Meta: &Metadata{License: "LicenseRef-sudo"}},
+		&Pkg{Name: "libpng", Meta: &Metadata{License: "libpng license"}},
+		&Pkg{Name: "nvidia", Meta: &Metadata{License: "MIT AND LicenseRef-NVIDIA"}},
+		&Pkg{Name: "perl", Meta: &Metadata{License: "GPLv1+ or Artistic"}},
+		&Pkg{Name: "perl-doc", Meta: &Metadata{License: "GPLv1+ or Artistic"}},
+		&Pkg{Name: "xz", Meta: &Metadata{License: "Public Domain"}},
+		&Pkg{Name: "xz-libs", Meta: &Metadata{License: "Public/Domain"}},
+		&Pkg{Name: "zlib", Meta: &Metadata{License: "(Zlib"}},
+	)
+
+	var buf bytes.Buffer
+	if err := WriteSBOM(&buf, fixture, FormatSPDX, SBOMOptions{}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	violations, err := validateSchema(sbomSchemas[FormatSPDX], buf.Bytes())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected SBOM to be valid, but got violations %v", violations)
+	}
+
+	var doc spdxDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the licenses that aren't SPDX license expressions are referred to by the ids of their extracted texts
+	expected := map[string]string{
+		"bash":     "GPL-3.0-or-later",
+		"libgcc":   "GPL-3.0-or-later WITH GCC-exception-3.1",
+		"openssl":  "(Apache-2.0 OR MIT) AND BSD-3-Clause",
+		"sudo":     "LicenseRef-sudo",
+		"libpng":   "LicenseRef-libpng-license",
+		"nvidia":   "MIT AND LicenseRef-NVIDIA",
+		"perl":     "LicenseRef-GPLv1--or-Artistic",
+		"perl-doc": "LicenseRef-GPLv1--or-Artistic",
+		"xz":       "LicenseRef-Public-Domain",
+		"xz-libs":  "LicenseRef-Public-Domain-2",
+		"zlib":     "LicenseRef--Zlib",
+	}
+	for _, p := range doc.Packages {
+		if p.LicenseDeclared != expected[p.Name] {
+			t.Errorf("Expected declared license of %s to be %q, but got %q", p.Name, expected[p.Name], p.LicenseDeclared)
+		}
+	}
+
+	extracted := make([]string, len(doc.Licenses))
+	for k, l := range doc.Licenses {
+		extracted[k] = l.LicenseID + "=" + l.ExtractedText
+	}
+	// so are the LicenseRef ids used by the license expressions
+	assertNames(t, "extracted licenses", extracted, []string{
+		"LicenseRef-libpng-license=libpng license",
+		"LicenseRef-NVIDIA=MIT AND LicenseRef-NVIDIA",
+		"LicenseRef-GPLv1--or-Artistic=GPLv1+ or Artistic",
+		"LicenseRef-sudo=LicenseRef-sudo",
+		"LicenseRef-Public-Domain=Public Domain",
+		"LicenseRef-Public-Domain-2=Public/Domain",
+		"LicenseRef--Zlib=(Zlib",
+	})
+}
+
+func TestWriteSBOM_CycloneDXLicenses(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	seedRegistry(fixture,
+		&Pkg{Name: "bash", Meta: &Metadata{License: "GPL-3.0-or-later"}},
+		&Pkg{Name: "curl", Meta: &Metadata{License: "curl"}},
+		&Pkg{Name: "libgcc", Meta: &Metadata{License: "GPL-3.0-or-later WITH GCC-exception-3.1"}},
+		&Pkg{Name: "libpng", Meta: &Metadata{License: "libpng license"}},
+		&Pkg{Name: "openssl", Meta: &Metadata{License: "(Apache-2.0 OR MIT) AND BSD-3-Clause"}},
+		&Pkg{Name: "readline", Meta: &Metadata{License: "GPL-2.0+"}},
+		&Pkg{Name: "sudo", Meta: &Metadata{License: "LicenseRef-sudo"}},
+		&Pkg{Name: "zlib", Meta: &Metadata{License: "Unknown-1.0"}},
+	)
+
+	var buf bytes.Buffer
+	if err := WriteSBOM(&buf, fixture, FormatCycloneDX, SBOMOptions{}); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	violations, err := validateSchema(sbomSchemas[FormatCycloneDX], buf.Bytes())
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected SBOM to be valid, but got violations %v", violations)
+	}
+
+	var bom cdxBOM
+	if err := json.Unmarshal(buf.Bytes(), &bom); err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the licenses of the SPDX license list are referred to by their ids, and the other SPDX license expressions are expressions
+	expected := map[string]string{
+		"bash":     "id=GPL-3.0-or-later",
+		"curl":     "id=curl",
+		"libgcc":   "expression=GPL-3.0-or-later WITH GCC-exception-3.1",
+		"libpng":   "name=libpng license",
+		"openssl":  "expression=(Apache-2.0 OR MIT) AND BSD-3-Clause",
+		"readline": "id=GPL-2.0+",
+		"sudo":     "expression=LicenseRef-sudo",
+		"zlib":     "expression=Unknown-1.0",
+	}
+	for _, c := range bom.Components {
+		var actual string
+		switch l := c.Licenses[0]; {
+		case l.Expression != "":
+			actual = "expression=" + l.Expression
+		case l.License.ID != "":
+			actual = "id=" + l.License.ID
+		default:
+			actual = "name=" + l.License.Name
+		}
+		if actual != expected[c.Name] {
+			t.Errorf("Expected license of %s to be %q, but got %q", c.Name, expected[c.Name], actual)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	t.Parallel()
+
+	// the validator catches the violations of the SBOM schemas
+	var tests = []struct {
+		format   string
+		doc      string
+		expected int
+	}{
+		{format: FormatSPDX, doc: `{"spdxVersion": "SPDX-2.3"}`, expected: 5},
+		{format: FormatSPDX, doc: `{"spdxVersion": "SPDX-2.3", "dataLicense": "CC0-1.0", "SPDXID": "SPDXRef-DOCUMENT", "name": "x", "documentNamespace": "x", "creationInfo": {"created": "2023-03-20T09:30:00Z", "creators": []}, "packages": [{"name": "x", "SPDXID": "x", "downloadLocation": "NOASSERTION", "licenseDeclared": 1}]}`, expected: 2},
+		{format: FormatCycloneDX, doc: `{"bomFormat": "CycloneDX", "specVersion": "1.5", "version": 1, "components": [{"type": "library", "name": "x", "licenses": [{"license": {}}]}]}`, expected: 1},
+		{format: FormatCycloneDX, doc: `{"bomFormat": "SPDX", "specVersion": "1.5", "version": 0}`, expected: 2},
+	}
+	for _, test := range tests {
+		violations, err := validateSchema(sbomSchemas[test.format], []byte(test.doc))
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if len(violations) != test.expected {
+			t.Errorf("Expected %d violations of %s, but got %v", test.expected, test.doc, violations)
+		}
+	}
+}
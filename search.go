@@ -0,0 +1,165 @@
+package indexer
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+// ErrInvalidPattern is an error message indicating a search pattern is neither a valid glob nor a valid regular expression.
+const ErrInvalidPattern = "Invalid search pattern"
+
+// Searcher is implemented by indexers that can search their packages by name patterns, and by the words of their descriptions.
+type Searcher interface {
+	Search(pattern string, opts SearchOptions) (*Page, error)
+	SearchText(query string, opts SearchOptions) (*Page, error)
+}
+
+// SearchOptions filters and paginates the packages returned by Search and SearchText.
+type SearchOptions struct {
+	// DependsOn selects the packages that depend directly on the package DependsOn.
+	DependsOn string
+
+	// NoDependents selects the packages that no other indexed package depends on.
+	NoDependents bool
+
+	// Limit is the maximum number of packages returned. A zero Limit returns all the matching packages.
+	Limit int
+
+	// Cursor resumes a search from the Next of its previous page.
+	Cursor string
+
+	// Selector selects the packages whose labels meet its requirements. An empty Selector selects all the packages.
+	Selector Selector
+}
+
+// Search returns the packages indexed in i whose names match pattern, and that are selected by opts, in alphabetical order.
+// A pattern enclosed in slashes, like /^lib.+-dev$/, is a regular expression in the syntax of the regexp package, which matches any part of the names unless it is anchored. Any other pattern is a glob, like lib*-dev, which matches whole names.
+// In globs, * matches any sequence of characters, ? matches any single character, and [...] matches a character class, which is negated by a leading !. A backslash escapes the following character.
+// It returns an error if pattern is invalid. If opts.Limit is reached, the returned page holds a cursor that resumes the search.
+func (i *InMemoryIndexer) Search(pattern string, opts SearchOptions) (*Page, error) {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	i.rlock()
+	defer i.runlock()
+
+	return i.search(re, opts), nil
+}
+
+// search returns a page of the packages whose names match re, and that are selected by opts, in alphabetical order.
+// The caller must hold i such that its registry can't change.
+func (i *InMemoryIndexer) search(re *regexp.Regexp, opts SearchOptions) *Page {
+	// only names that start with the literal prefix of the pattern need to be matched
+	prefix := literalPrefix(re)
+	start := prefix
+	if opts.Cursor > start {
+		start = opts.Cursor
+	}
+
+	return i.scan(start, opts.Limit, func(p *Pkg) (selected, stop bool) {
+		if !strings.HasPrefix(p.Name, prefix) {
+			return false, true
+		}
+		return re.MatchString(p.Name) && i.filter(p, opts), false
+	})
+}
+
+// filter returns true if p is selected by the filters of opts.
+// The caller must hold i such that its registry can't change.
+func (i *InMemoryIndexer) filter(p *Pkg, opts SearchOptions) bool {
+	if opts.DependsOn != "" && !dependsOn(p, opts.DependsOn) {
+		return false
+	}
+
+	if opts.NoDependents && i.registry.dependents(p.Name) > 0 {
+		return false
+	}
+
+	return opts.Selector.selects(p)
+}
+
+func dependsOn(p *Pkg, name string) bool {
+	for _, d := range p.Deps {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePattern compiles a search pattern into a regular expression. See Search for the syntax of the patterns.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	expr := pattern
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		expr = pattern[1 : len(pattern)-1]
+	} else {
+		var ok bool
+		if expr, ok = globExpr(pattern); !ok {
+			return nil, fmt.Errorf(ErrInvalidPattern)
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf(ErrInvalidPattern)
+	}
+	return re, nil
+}
+
+// globExpr translates glob into an equivalent regular expression, anchored at both ends.
+// It returns false if glob has an unterminated character class or a trailing backslash.
+func globExpr(glob string) (string, bool) {
+	var b strings.Builder
+	b.WriteString("^")
+	for k := 0; k < len(glob); k++ {
+		switch c := glob[k]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			k++
+			if k == len(glob) {
+				return "", false
+			}
+			b.WriteString(regexp.QuoteMeta(glob[k : k+1]))
+		case '[':
+			end := strings.IndexByte(glob[k+1:], ']')
+			if end < 0 {
+				return "", false
+			}
+			class := glob[k+1 : k+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			k += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[k : k+1]))
+		}
+	}
+	b.WriteString("$")
+	return b.String(), true
+}
+
+// literalPrefix returns a prefix that all the names matched by re start with. It is empty unless re is anchored at the beginning of the names.
+func literalPrefix(re *regexp.Regexp) string {
+	r, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+
+	r = r.Simplify()
+	if r.Op != syntax.OpConcat || len(r.Sub) < 2 || r.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+
+	if lit := r.Sub[1]; lit.Op == syntax.OpLiteral && lit.Flags&syntax.FoldCase == 0 {
+		return string(lit.Rune)
+	}
+	return ""
+}
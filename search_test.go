@@ -0,0 +1,88 @@
+package indexer
+
+import "testing"
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "openssl"},
+		{Name: "zlib"},
+		{Name: "libssl-dev", Deps: []string{"openssl"}},
+		{Name: "libz-dev", Deps: []string{"zlib"}},
+		{Name: "libcurl", Deps: []string{"openssl", "zlib"}},
+		{Name: "libcurl-dev", Deps: []string{"libcurl", "libssl-dev"}},
+		{Name: "curl", Deps: []string{"libcurl"}},
+	} {
+		if res := fixture.Index(p); res != OK {
+			t.Fatalf("Expected %q to be indexed, but got %q", p.Name, res)
+		}
+	}
+
+	var tests = []struct {
+		pattern  string
+		opts     SearchOptions
+		expected []string
+	}{
+		{pattern: "lib*-dev", expected: []string{"libcurl-dev", "libssl-dev", "libz-dev"}},
+		{pattern: "lib?-dev", expected: []string{"libz-dev"}},
+		{pattern: "[!l]*", expected: []string{"curl", "openssl", "zlib"}},
+		{pattern: "*curl", expected: []string{"curl", "libcurl"}},
+		{pattern: "curl", expected: []string{"curl"}},
+		{pattern: "/curl/", expected: []string{"curl", "libcurl", "libcurl-dev"}},
+		{pattern: "/^lib.+-dev$/", expected: []string{"libcurl-dev", "libssl-dev", "libz-dev"}},
+		{pattern: "*", opts: SearchOptions{DependsOn: "openssl"}, expected: []string{"libcurl", "libssl-dev"}},
+		{pattern: "lib*", opts: SearchOptions{NoDependents: true}, expected: []string{"libcurl-dev", "libz-dev"}},
+		{pattern: "*", opts: SearchOptions{DependsOn: "zlib", NoDependents: true}, expected: []string{"libz-dev"}},
+		{pattern: "lib*", opts: SearchOptions{Limit: 2, Cursor: "libcurl-dev"}, expected: []string{"libcurl-dev", "libssl-dev"}},
+	}
+
+	for _, test := range tests {
+		page, err := fixture.Search(test.pattern, test.opts)
+		if err != nil {
+			t.Errorf("Unexpected error for pattern %q: %v", test.pattern, err)
+			continue
+		}
+
+		names := make([]string, len(page.Pkgs))
+		for k, p := range page.Pkgs {
+			names[k] = p.Name
+		}
+		assertNames(t, "packages matching "+test.pattern, names, test.expected)
+	}
+
+	for _, pattern := range []string{"lib[", "lib\\", "/lib(/"} {
+		if _, err := fixture.Search(pattern, SearchOptions{}); err == nil || err.Error() != ErrInvalidPattern {
+			t.Errorf("Expected error for pattern %q to be %q, but got %v", pattern, ErrInvalidPattern, err)
+		}
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		pattern  string
+		expected string
+	}{
+		{pattern: "lib*-dev", expected: "lib"},
+		{pattern: "*-dev", expected: ""},
+		{pattern: "lib\\*", expected: "lib*"},
+		{pattern: "/^lib/", expected: "lib"},
+		{pattern: "/lib/", expected: ""},
+		{pattern: "/^lib|^z/", expected: ""},
+		{pattern: "/(?i)^lib/", expected: ""},
+	}
+
+	for _, test := range tests {
+		re, err := compilePattern(test.pattern)
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+
+		if actual := literalPrefix(re); actual != test.expected {
+			t.Errorf("Expected literal prefix of %q to be %q, but got %q", test.pattern, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrMalformedSelector is an error message indicating a label selector is malformed.
+const ErrMalformedSelector = "Malformed label selector"
+
+// Selector selects packages by their labels. A package is selected if it meets all the requirements of the selector.
+// An empty selector selects all the packages.
+type Selector []requirement
+
+// requirement is a condition on the value of the label key.
+type requirement struct {
+	key    string
+	op     string
+	values []string
+}
+
+const (
+	reqEquals    = "="
+	reqNotEquals = "!="
+	reqIn        = "in"
+	reqNotIn     = "notin"
+	reqExists    = "exists"
+	reqNotExists = "!"
+)
+
+// ParseSelector parses a comma-separated list of requirements on the labels of packages, in the syntax of Kubernetes label selectors:
+//
+//	team=payments       the label team is payments (also team==payments)
+//	tier!=dev           the label tier isn't dev, or isn't set
+//	tier in (prod,qa)   the label tier is either prod or qa
+//	tier notin (dev)    the label tier is neither of the values, or isn't set
+//	team                the label team is set
+//	!team               the label team isn't set
+//
+// E.g. team=payments,tier!=dev. It returns an error if s is malformed.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, r := range splitRequirements(s) {
+		req, ok := parseRequirement(strings.TrimSpace(r))
+		if !ok {
+			return nil, fmt.Errorf(ErrMalformedSelector)
+		}
+		sel = append(sel, req)
+	}
+	return sel, nil
+}
+
+// splitRequirements splits s at the commas that aren't within parentheses.
+func splitRequirements(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	var reqs []string
+	depth, start := 0, 0
+	for k, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				reqs = append(reqs, s[start:k])
+				start = k + 1
+			}
+		}
+	}
+	return append(reqs, s[start:])
+}
+
+func parseRequirement(s string) (requirement, bool) {
+	if strings.HasPrefix(s, "!") {
+		key := strings.TrimSpace(s[1:])
+		return requirement{key: key, op: reqNotExists}, validLabel(key)
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if k := strings.Index(s, op); k >= 0 {
+			key, value := strings.TrimSpace(s[:k]), strings.TrimSpace(s[k+len(op):])
+			if op == "==" {
+				op = reqEquals
+			}
+			return requirement{key: key, op: op, values: []string{value}}, validLabel(key) && (value == "" || validLabel(value))
+		}
+	}
+
+	if fields := strings.Fields(s); len(fields) >= 2 && (fields[1] == reqIn || fields[1] == reqNotIn) {
+		key, op := fields[0], fields[1]
+		set := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s[len(key):]), op))
+		if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+			return requirement{}, false
+		}
+
+		req := requirement{key: key, op: op}
+		for _, v := range strings.Split(set[1:len(set)-1], ",") {
+			v = strings.TrimSpace(v)
+			if !validLabel(v) {
+				return requirement{}, false
+			}
+			req.values = append(req.values, v)
+		}
+		return req, validLabel(key)
+	}
+
+	return requirement{key: s, op: reqExists}, validLabel(s)
+}
+
+// validLabel returns true if s is a non-empty label key or value, which doesn't hold any of the characters of the selector syntax, nor whitespaces.
+func validLabel(s string) bool {
+	return s != "" && !strings.ContainsAny(s, "=!(), \t")
+}
+
+// Matches returns true if labels meet all the requirements of s.
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		if !req.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// selects returns true if p is selected by s.
+func (s Selector) selects(p *Pkg) bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	var labels map[string]string
+	if p.Meta != nil {
+		labels = p.Meta.Labels
+	}
+	return s.Matches(labels)
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, exist := labels[r.key]
+	switch r.op {
+	case reqEquals:
+		return exist && value == r.values[0]
+	case reqNotEquals:
+		return !exist || value != r.values[0]
+	case reqIn:
+		return exist && contains(r.values, value)
+	case reqNotIn:
+		return !exist || !contains(r.values, value)
+	case reqExists:
+		return exist
+	case reqNotExists:
+		return !exist
+	}
+	return false
+}
+
+// String formats s in the syntax of ParseSelector.
+func (s Selector) String() string {
+	reqs := make([]string, len(s))
+	for k, r := range s {
+		switch r.op {
+		case reqEquals, reqNotEquals:
+			reqs[k] = r.key + r.op + r.values[0]
+		case reqIn, reqNotIn:
+			reqs[k] = r.key + " " + r.op + " (" + strings.Join(r.values, ",") + ")"
+		case reqExists:
+			reqs[k] = r.key
+		case reqNotExists:
+			reqs[k] = "!" + r.key
+		}
+	}
+	return strings.Join(reqs, ",")
+}
+
+func contains(values []string, v string) bool {
+	for _, w := range values {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package indexer
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"team": "payments", "tier": "prod"}
+	var tests = []struct {
+		selector string
+		expected bool
+	}{
+		{selector: "", expected: true},
+		{selector: "team=payments", expected: true},
+		{selector: "team==payments", expected: true},
+		{selector: "team=search", expected: false},
+		{selector: "team=payments,tier!=dev", expected: true},
+		{selector: "team=payments, tier!=prod", expected: false},
+		{selector: "owner!=alice", expected: true},
+		{selector: "tier in (prod,qa)", expected: true},
+		{selector: "tier in (dev)", expected: false},
+		{selector: "tier notin (dev,qa),team", expected: true},
+		{selector: "owner notin (alice)", expected: true},
+		{selector: "team,!owner", expected: true},
+		{selector: "!team", expected: false},
+		{selector: "owner=", expected: false},
+	}
+
+	for _, test := range tests {
+		sel, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Errorf("Unexpected error for selector %q: %v", test.selector, err)
+			continue
+		}
+
+		if actual := sel.Matches(labels); actual != test.expected {
+			t.Errorf("Expected selector %q to match %v to be %t", test.selector, labels, test.expected)
+		}
+
+		// the selector is formatted into an equivalent one
+		again, err := ParseSelector(sel.String())
+		if err != nil || again.String() != sel.String() {
+			t.Errorf("Expected selector %q to be formatted consistently, but got %q", test.selector, sel.String())
+		}
+	}
+
+	for _, selector := range []string{"team=pay=ments", "=payments", "tier in prod", "tier in (prod,)", "!", "team payments", "tier in (prod"} {
+		if _, err := ParseSelector(selector); err == nil || err.Error() != ErrMalformedSelector {
+			t.Errorf("Expected error for selector %q to be %q, but got %v", selector, ErrMalformedSelector, err)
+		}
+	}
+}
+
+func TestSelector_ListAndSearch(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "ledger", Meta: &Metadata{Labels: map[string]string{"team": "payments", "tier": "prod"}}},
+		{Name: "ledger-mock", Meta: &Metadata{Labels: map[string]string{"team": "payments", "tier": "dev"}}},
+		{Name: "ledger-ui", Meta: &Metadata{Labels: map[string]string{"team": "payments"}}},
+		{Name: "indexer", Meta: &Metadata{Labels: map[string]string{"team": "search", "tier": "prod"}}},
+		{Name: "libc"},
+	} {
+		fixture.Index(p)
+	}
+
+	sel, err := ParseSelector("team=payments,tier!=dev")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	page := fixture.List(ListOptions{Selector: sel, Limit: 1})
+	if len(page.Pkgs) != 1 || page.Pkgs[0].Name != "ledger" || page.Next != "ledger-ui" {
+		t.Errorf("Expected first page to hold ledger, followed by ledger-ui, but got %+v", page)
+	}
+
+	page, err = fixture.Search("*", SearchOptions{Selector: sel})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	names := make([]string, len(page.Pkgs))
+	for k, p := range page.Pkgs {
+		names[k] = p.Name
+	}
+	assertNames(t, "selected packages", names, []string{"ledger", "ledger-ui"})
+}
@@ -0,0 +1,139 @@
+package indexer
+
+import "sort"
+
+// StatsReporter is implemented by indexers that can report statistics about their packages and dependency graph.
+type StatsReporter interface {
+	Stats(top int) *Stats
+}
+
+// Stats holds statistics about the packages of an indexer, and their dependency graph.
+type Stats struct {
+	// Packages is the number of indexed packages.
+	Packages int
+
+	// Edges is the number of dependencies between the packages.
+	Edges int
+
+	// Roots is the number of packages that no other package depends on.
+	Roots int
+
+	// Leaves is the number of packages that don't depend on any package.
+	Leaves int
+
+	// MaxDepth is the length of the longest chain of dependencies, from a package down to a leaf. The depth of a leaf is 0.
+	MaxDepth int
+
+	// AvgDepth is the average depth of the packages.
+	AvgDepth float64
+
+	// MostDepended holds the packages with the most dependents, in decreasing order of dependents.
+	MostDepended []Ranking
+
+	// FanIn is the distribution of the number of dependents of the packages.
+	FanIn Distribution
+
+	// FanOut is the distribution of the number of dependencies of the packages.
+	FanOut Distribution
+}
+
+// Ranking is the number of dependents of a package.
+type Ranking struct {
+	Name       string
+	Dependents int
+}
+
+// Distribution counts the packages by their number of edges, in buckets of powers of two: Buckets[0] counts the packages without edges, and Buckets[k] counts the packages with 2^(k-1) to 2^k-1 edges.
+type Distribution struct {
+	Max     int
+	Buckets []int
+}
+
+// add counts a package with n edges.
+func (d *Distribution) add(n int) {
+	if n > d.Max {
+		d.Max = n
+	}
+
+	b := 0
+	for ; n > 0; n >>= 1 {
+		b++
+	}
+	for len(d.Buckets) <= b {
+		d.Buckets = append(d.Buckets, 0)
+	}
+	d.Buckets[b]++
+}
+
+// BucketRange returns the smallest and the largest number of edges counted by the bucket k.
+func BucketRange(k int) (min, max int) {
+	if k == 0 {
+		return 0, 0
+	}
+	return 1 << uint(k-1), 1<<uint(k) - 1
+}
+
+// Stats returns statistics about the packages indexed in i, with the top packages that have the most dependents. Packages with the same number of dependents are ranked in alphabetical order.
+func (i *InMemoryIndexer) Stats(top int) *Stats {
+	i.rlock()
+	defer i.runlock()
+
+	var pkgs []*Pkg
+	i.registry.each(func(p *Pkg) bool {
+		pkgs = append(pkgs, p)
+		return true
+	})
+
+	stats := &Stats{Packages: len(pkgs)}
+	ranking := make([]Ranking, 0, len(pkgs))
+	for _, p := range pkgs {
+		dependents := i.registry.dependents(p.Name)
+		stats.Edges += len(p.Deps)
+		stats.FanIn.add(dependents)
+		stats.FanOut.add(len(p.Deps))
+		if dependents == 0 {
+			stats.Roots++
+		}
+		if len(p.Deps) == 0 {
+			stats.Leaves++
+		}
+		if dependents > 0 {
+			ranking = append(ranking, Ranking{Name: p.Name, Dependents: dependents})
+		}
+	}
+
+	sort.Slice(ranking, func(a, b int) bool {
+		if ranking[a].Dependents != ranking[b].Dependents {
+			return ranking[a].Dependents > ranking[b].Dependents
+		}
+		return ranking[a].Name < ranking[b].Name
+	})
+	if top < 0 {
+		top = 0
+	}
+	if len(ranking) > top {
+		ranking = ranking[:top]
+	}
+	stats.MostDepended = ranking
+
+	// dependencies are sorted before their dependents, so their depths are known first
+	depths := make(map[string]int, len(pkgs))
+	total := 0
+	for _, p := range sortByDeps(pkgs) {
+		depth := 0
+		for _, d := range p.Deps {
+			if depths[d]+1 > depth {
+				depth = depths[d] + 1
+			}
+		}
+		depths[p.Name] = depth
+		total += depth
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+	if len(pkgs) > 0 {
+		stats.AvgDepth = float64(total) / float64(len(pkgs))
+	}
+	return stats
+}
@@ -0,0 +1,74 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	if st := fixture.Stats(3); st.Packages != 0 || st.AvgDepth != 0 || len(st.MostDepended) != 0 {
+		t.Errorf("Expected an empty registry to have empty stats, but got %+v", st)
+	}
+
+	for _, p := range []*Pkg{
+		{Name: "openssl"},
+		{Name: "zlib"},
+		{Name: "pcre"},
+		{Name: "nginx", Deps: []string{"openssl", "zlib", "pcre"}},
+		{Name: "libcurl", Deps: []string{"openssl", "zlib"}},
+		{Name: "curl", Deps: []string{"libcurl"}},
+		{Name: "git", Deps: []string{"libcurl", "pcre"}},
+		{Name: "jq"},
+	} {
+		if res := fixture.Index(p); res != OK {
+			t.Fatalf("Expected %q to be indexed, but got %q", p.Name, res)
+		}
+	}
+
+	expected := &Stats{
+		Packages:     8,
+		Edges:        8,
+		Roots:        4,
+		Leaves:       4,
+		MaxDepth:     2,
+		AvgDepth:     0.75,
+		MostDepended: []Ranking{{Name: "libcurl", Dependents: 2}, {Name: "openssl", Dependents: 2}},
+		FanIn:        Distribution{Max: 2, Buckets: []int{4, 0, 4}},
+		FanOut:       Distribution{Max: 3, Buckets: []int{4, 1, 3}},
+	}
+	if actual := fixture.Stats(2); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected stats to be %+v, but got %+v", expected, actual)
+	}
+
+	if actual := fixture.Stats(-1); len(actual.MostDepended) != 0 {
+		t.Errorf("Expected no most depended-on packages, but got %+v", actual.MostDepended)
+	}
+}
+
+func TestBucketRange(t *testing.T) {
+	var tests = []struct {
+		bucket   int
+		min, max int
+	}{
+		{bucket: 0, min: 0, max: 0},
+		{bucket: 1, min: 1, max: 1},
+		{bucket: 2, min: 2, max: 3},
+		{bucket: 5, min: 16, max: 31},
+	}
+
+	for _, test := range tests {
+		d := &Distribution{}
+		d.add(test.min)
+		d.add(test.max)
+		if len(d.Buckets) != test.bucket+1 || d.Buckets[test.bucket] != 2 {
+			t.Errorf("Expected %d and %d to be counted in bucket %d, but got %v", test.min, test.max, test.bucket, d.Buckets)
+		}
+
+		if min, max := BucketRange(test.bucket); min != test.min || max != test.max {
+			t.Errorf("Expected range of bucket %d to be %d-%d, but got %d-%d", test.bucket, test.min, test.max, min, max)
+		}
+	}
+}
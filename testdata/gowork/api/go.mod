@@ -0,0 +1,11 @@
+module example.com/api
+
+go 1.21
+
+require (
+	example.com/lib v0.1.0
+	github.com/gorilla/mux v1.8.0
+	golang.org/x/text v0.3.7 // indirect
+)
+
+replace golang.org/x/text => golang.org/x/text v0.14.0
@@ -0,0 +1,7 @@
+module example.com/lib
+
+go 1.21
+
+require golang.org/x/text v0.3.7
+
+replace github.com/pkg/errors v0.9.1 => ../errors
@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptyQuery is an error message indicating a full-text query doesn't have any words.
+const ErrEmptyQuery = "Query has no words"
+
+// textIndex is an inverted index of the words of the descriptions of indexed packages.
+type textIndex struct {
+	// postings holds the ids of the packages whose descriptions have each word.
+	postings map[string]map[uint32]struct{}
+}
+
+// add indexes the words of the description of package id.
+func (x *textIndex) add(id uint32, description string) {
+	for _, w := range words(description) {
+		if x.postings == nil {
+			x.postings = map[string]map[uint32]struct{}{}
+		}
+
+		ids, exist := x.postings[w]
+		if !exist {
+			ids = map[uint32]struct{}{}
+			x.postings[w] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// drop removes the words of the description of package id from x.
+func (x *textIndex) drop(id uint32, description string) {
+	for _, w := range words(description) {
+		delete(x.postings[w], id)
+		if len(x.postings[w]) == 0 {
+			delete(x.postings, w)
+		}
+	}
+}
+
+// lookup returns the ids of the packages whose descriptions have all the words.
+func (x *textIndex) lookup(words []string) []uint32 {
+	// intersect the postings starting from the shortest
+	postings := make([]map[uint32]struct{}, len(words))
+	for k, w := range words {
+		postings[k] = x.postings[w]
+	}
+	sort.Slice(postings, func(a, b int) bool {
+		return len(postings[a]) < len(postings[b])
+	})
+
+	var ids []uint32
+	for id := range postings[0] {
+		found := true
+		for _, others := range postings[1:] {
+			if _, found = others[id]; !found {
+				break
+			}
+		}
+		if found {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// words splits s into its distinct lower-case words, made of letters and digits.
+func words(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	distinct := fields[:0]
+	for _, f := range fields {
+		if !seen[f] {
+			seen[f] = true
+			distinct = append(distinct, f)
+		}
+	}
+	return distinct
+}
+
+// SearchText returns the packages indexed in i whose descriptions have all the words of query, and that are selected by opts, in alphabetical order.
+// Words are made of letters and digits, and are matched regardless of case.
+// It returns an error if query has no words. If opts.Limit is reached, the returned page holds a cursor that resumes the search.
+func (i *InMemoryIndexer) SearchText(query string, opts SearchOptions) (*Page, error) {
+	w := words(query)
+	if len(w) == 0 {
+		return nil, fmt.Errorf(ErrEmptyQuery)
+	}
+
+	i.rlock()
+	defer i.runlock()
+
+	var pkgs []*Pkg
+	for _, p := range i.registry.describedBy(w) {
+		if i.filter(p, opts) {
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Sort(byName(pkgs))
+	return paginate(pkgs, opts), nil
+}
@@ -0,0 +1,91 @@
+package indexer
+
+import "testing"
+
+func TestSearchText(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	for _, p := range []*Pkg{
+		{Name: "zlib", Meta: &Metadata{Description: "A massively spiffy yet delicately unobtrusive compression library"}},
+		{Name: "xz", Meta: &Metadata{Description: "General-purpose data compression with a high compression ratio"}},
+		{Name: "libpng", Deps: []string{"zlib"}, Meta: &Metadata{Description: "Library for manipulating PNG images"}},
+		{Name: "brotli", Meta: &Metadata{Description: "Generic-purpose lossless compression algorithm"}},
+		{Name: "pcre"},
+	} {
+		fixture.Index(p)
+	}
+
+	var tests = []struct {
+		query    string
+		opts     SearchOptions
+		expected []string
+	}{
+		{query: "compression", expected: []string{"brotli", "xz", "zlib"}},
+		{query: "COMPRESSION library", expected: []string{"zlib"}},
+		{query: "library", expected: []string{"libpng", "zlib"}},
+		{query: "library", opts: SearchOptions{NoDependents: true}, expected: []string{"libpng"}},
+		{query: "purpose-compression", expected: []string{"brotli", "xz"}},
+		{query: "compression", opts: SearchOptions{Limit: 2}, expected: []string{"brotli", "xz"}},
+		{query: "compression", opts: SearchOptions{Limit: 2, Cursor: "zlib"}, expected: []string{"zlib"}},
+		{query: "images compression", expected: nil},
+		{query: "perl", expected: nil},
+	}
+
+	for _, test := range tests {
+		page, err := fixture.SearchText(test.query, test.opts)
+		if err != nil {
+			t.Errorf("Unexpected error for query %q: %v", test.query, err)
+			continue
+		}
+
+		names := make([]string, len(page.Pkgs))
+		for k, p := range page.Pkgs {
+			names[k] = p.Name
+		}
+		assertNames(t, "packages described by "+test.query, names, test.expected)
+	}
+
+	if _, err := fixture.SearchText(" - ", SearchOptions{}); err == nil || err.Error() != ErrEmptyQuery {
+		t.Errorf("Expected error to be %q, but got %v", ErrEmptyQuery, err)
+	}
+}
+
+func TestSearchText_Changes(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	fixture.Index(&Pkg{Name: "xz", Meta: &Metadata{Description: "Data compression"}})
+	fixture.Index(&Pkg{Name: "zstd", Meta: &Metadata{Description: "Fast compression"}})
+
+	// descriptions leave the index along with their packages, or when they are replaced
+	fixture.Remove("xz")
+	fixture.Annotate("zstd", &Metadata{Description: "Fast real-time compressor"})
+	for _, query := range []string{"compression", "data"} {
+		page, err := fixture.SearchText(query, SearchOptions{})
+		if err != nil {
+			t.Fatal("Unexpected error: ", err)
+		}
+		if len(page.Pkgs) != 0 {
+			t.Errorf("Expected no package to be described by %q, but got %+v", query, page.Pkgs)
+		}
+	}
+
+	page, err := fixture.SearchText("compressor", SearchOptions{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	if len(page.Pkgs) != 1 || page.Pkgs[0].Name != "zstd" {
+		t.Errorf("Expected zstd to be described by %q, but got %+v", "compressor", page.Pkgs)
+	}
+
+	words := map[string]bool{}
+	for _, s := range fixture.registry.shards {
+		for w := range s.text.postings {
+			words[w] = true
+		}
+	}
+	if len(words) != 4 {
+		t.Errorf("Expected only the words of zstd to be indexed, but got %v", words)
+	}
+}
@@ -0,0 +1,310 @@
+package indexer
+
+// Transactor is implemented by indexers that can stage multiple operations and apply them all at once.
+type Transactor interface {
+	Begin() *Tx
+}
+
+// Tx stages INDEX and REMOVE operations against an InMemoryIndexer so that they are either all applied, or none of them are.
+// Within a transaction, the dependencies of a package may be satisfied by packages staged earlier in the same transaction.
+// Tx implements the Indexer interface, where Query sees the staged operations. A Tx isn't safe for concurrent use.
+type Tx struct {
+	i    *InMemoryIndexer
+	o    *overlay
+	done bool
+
+	// rev is the revision of the registry that the numbers of dependents counted by o are relative to.
+	rev uint64
+}
+
+// Begin starts a new transaction on i.
+func (i *InMemoryIndexer) Begin() *Tx {
+	return &Tx{i: i, o: newOverlay(i.registry)}
+}
+
+// Index stages p to be indexed when t is committed.
+// It returns OK if p could be indexed or if it was already present, taking earlier staged operations into account.
+// It returns Fail if some of the dependencies of p aren't indexed or staged.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Index(p *Pkg) string {
+	if t.done {
+		return Error
+	}
+
+	t.i.rlock()
+	defer t.i.runlock()
+
+	return t.o.index(p)
+}
+
+// Remove stages package name to be removed when t is committed.
+// It returns OK if name could be removed, or if it isn't indexed or staged.
+// It returns Fail if some other indexed or staged package depends on name.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Remove(name string) string {
+	if t.done {
+		return Error
+	}
+
+	t.i.rlock()
+	defer t.i.runlock()
+
+	t.recount()
+	return t.o.remove(name)
+}
+
+// Annotate stages the replacement of the metadata of package name with m. It has the same semantics as InMemoryIndexer.Annotate, taking earlier staged operations into account.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Annotate(name string, m *Metadata) string {
+	if t.done {
+		return Error
+	}
+
+	t.i.rlock()
+	defer t.i.runlock()
+
+	return t.o.annotate(name, m)
+}
+
+// Describe returns package name with its metadata, taking the operations staged in t into account.
+// It returns nil if name isn't indexed or staged, or if t is already committed or aborted.
+func (t *Tx) Describe(name string) *Pkg {
+	if t.done {
+		return nil
+	}
+
+	t.i.rlock()
+	defer t.i.runlock()
+
+	p, _ := t.o.lookup(name)
+	return p
+}
+
+// Query checks if name is indexed, taking the operations staged in t into account.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Query(name string) string {
+	if t.done {
+		return Error
+	}
+
+	t.i.rlock()
+	defer t.i.runlock()
+
+	return t.o.query(name)
+}
+
+// Commit applies all the staged operations of t to the registry.
+// It returns OK if all the operations could be applied.
+// It returns Fail if the registry has changed since the operations were staged, such that some of them now violate the dependencies constraints. In that case, none of the operations are applied.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Commit() string {
+	if t.done {
+		return Error
+	}
+	t.done = true
+
+	defer t.i.settle()
+	t.i.m.Lock()
+	defer t.i.m.Unlock()
+
+	o := t.o.replay(t.i.registry)
+	if o == nil {
+		return Fail
+	}
+
+	t.i.apply(o)
+	return OK
+}
+
+// recount counts the dependents of the packages staged in t again if the registry has changed since they were counted.
+// The caller must hold t.i such that its registry can't change.
+func (t *Tx) recount() {
+	if rev := t.i.Revision(); rev != t.rev {
+		t.o.recount()
+		t.rev = rev
+	}
+}
+
+// Abort discards all the staged operations of t.
+// It returns Error if t is already committed or aborted.
+func (t *Tx) Abort() string {
+	if t.done {
+		return Error
+	}
+
+	t.done = true
+	t.o = nil
+	return OK
+}
+
+// overlay stages changes on top of a base view, without modifying the base.
+type overlay struct {
+	base view
+
+	// staged holds the changed packages. A nil value marks a removed package.
+	staged map[string]*Pkg
+
+	// counts holds the differences between the numbers of dependents of packages in the overlay and in base, such that removals don't need to scan the overlay.
+	// They are only kept up to date by the changes to the overlay. If base changes the packages staged in the overlay, they must be recounted.
+	counts map[string]int
+
+	// ops records the operations that changed the overlay, in order.
+	ops []stagedOp
+}
+
+// stagedOp is a staged operation of command op, either "INDEX", "REMOVE" or "ANNOTATE". pkg is nil for removals.
+type stagedOp struct {
+	op   string
+	name string
+	pkg  *Pkg
+}
+
+func newOverlay(base view) *overlay {
+	return &overlay{
+		base:   base,
+		staged: map[string]*Pkg{},
+		counts: map[string]int{},
+	}
+}
+
+func (o *overlay) lookup(name string) (*Pkg, bool) {
+	if p, staged := o.staged[name]; staged {
+		return p, p != nil
+	}
+	return o.base.lookup(name)
+}
+
+func (o *overlay) each(fn func(*Pkg) bool) {
+	ok := true
+	o.base.each(func(p *Pkg) bool {
+		if _, staged := o.staged[p.Name]; staged {
+			return true
+		}
+		ok = fn(p)
+		return ok
+	})
+
+	if !ok {
+		return
+	}
+
+	for _, p := range o.staged {
+		if p != nil && !fn(p) {
+			return
+		}
+	}
+}
+
+func (o *overlay) index(p *Pkg) string {
+	if _, exist := o.lookup(p.Name); exist {
+		return OK
+	}
+
+	if !canIndex(o, p) {
+		return Fail
+	}
+
+	o.stage(p.Name, p)
+	o.ops = append(o.ops, stagedOp{op: opIndex, name: p.Name, pkg: p})
+	return OK
+}
+
+func (o *overlay) remove(name string) string {
+	if _, exist := o.lookup(name); !exist {
+		return OK
+	}
+
+	if o.dependents(name) > 0 {
+		return Fail
+	}
+
+	o.stage(name, nil)
+	o.ops = append(o.ops, stagedOp{op: opRemove, name: name})
+	return OK
+}
+
+// annotate stages the replacement of the metadata of package name with m.
+func (o *overlay) annotate(name string, m *Metadata) string {
+	p, exist := o.lookup(name)
+	if !exist {
+		return Fail
+	}
+
+	if sameMeta(p.Meta, m) {
+		return OK
+	}
+
+	a := &Pkg{Name: name, Deps: p.Deps, Meta: m.clone()}
+	o.stage(name, a)
+	o.ops = append(o.ops, stagedOp{op: opAnnotate, name: name, pkg: a})
+	return OK
+}
+
+func (o *overlay) dependents(name string) int {
+	return o.base.dependents(name) + o.counts[name]
+}
+
+// stage replaces the version of name in o with p, and counts the dependents p adds or drops. A nil p removes name.
+func (o *overlay) stage(name string, p *Pkg) {
+	if prev, exist := o.lookup(name); exist {
+		o.count(prev, -1)
+	}
+	o.count(p, 1)
+	o.staged[name] = p
+}
+
+// recount counts the dependents that the staged packages add or drop again, as the versions of those packages in base may have changed.
+func (o *overlay) recount() {
+	o.counts = map[string]int{}
+	for name, p := range o.staged {
+		if prev, exist := o.base.lookup(name); exist {
+			o.count(prev, -1)
+		}
+		o.count(p, 1)
+	}
+}
+
+// count adds n to the numbers of dependents of the dependencies of p, unless p is nil.
+func (o *overlay) count(p *Pkg, n int) {
+	if p == nil {
+		return
+	}
+
+	for _, d := range p.Deps {
+		o.counts[d] += n
+		if o.counts[d] == 0 {
+			delete(o.counts, d)
+		}
+	}
+}
+
+func (o *overlay) query(name string) string {
+	if _, exist := o.lookup(name); exist {
+		return OK
+	}
+
+	return Fail
+}
+
+// replay re-applies the operations of o, in order, on top of v, with the same semantics they had when they were staged.
+// An INDEX of a package that v has indexed since is a no-op, and an ANNOTATE of a package that v has removed since fails.
+// It returns nil if any of the operations no longer satisfies the dependencies constraints.
+func (o *overlay) replay(v view) *overlay {
+	r := newOverlay(v)
+	for _, op := range o.ops {
+		var res string
+		switch op.op {
+		case opIndex:
+			res = r.index(op.pkg)
+		case opRemove:
+			res = r.remove(op.name)
+		case opAnnotate:
+			res = r.annotate(op.name, op.pkg.Meta)
+		}
+
+		if res != OK {
+			return nil
+		}
+	}
+	return r
+}
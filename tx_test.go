@@ -0,0 +1,226 @@
+package indexer
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTx_Commit_OK(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	pcre := &Pkg{Name: "pcre-8.38"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name, zlib.Name}}
+	seedRegistry(fixture, zlib)
+
+	tx := fixture.Begin()
+	for _, p := range []*Pkg{pcre, nginx} {
+		if res := tx.Index(p); res != OK {
+			t.Errorf("Expected Index() of %q to return %q, but got %q", p.Name, OK, res)
+		}
+	}
+
+	// staged packages aren't visible outside of the transaction
+	assertNotExist(fixture, nginx, t)
+	if res := tx.Query(nginx.Name); res != OK {
+		t.Errorf("Expected Query() within transaction to return %q, but got %q", OK, res)
+	}
+
+	if res := tx.Commit(); res != OK {
+		t.Fatalf("Expected Commit() to return %q, but got %q", OK, res)
+	}
+
+	for _, p := range []*Pkg{zlib, pcre, nginx} {
+		assertExist(fixture, p, t)
+	}
+}
+
+func TestTx_Index_Fail_MissingDeps(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	nginx := &Pkg{Name: "nginx", Deps: []string{"pcre-8.38"}}
+
+	tx := fixture.Begin()
+	if res := tx.Index(nginx); res != Fail {
+		t.Errorf("Expected Index() to return %q, but got %q", Fail, res)
+	}
+
+	if res := tx.Commit(); res != OK {
+		t.Errorf("Expected Commit() to return %q, but got %q", OK, res)
+	}
+	assertNotExist(fixture, nginx, t)
+}
+
+func TestTx_Remove(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, pcre, nginx)
+
+	tx := fixture.Begin()
+	if res := tx.Remove(pcre.Name); res != Fail {
+		t.Errorf("Expected Remove() of %q to return %q, but got %q", pcre.Name, Fail, res)
+	}
+
+	// removing the dependent first satisfies the constraints
+	for _, p := range []*Pkg{nginx, pcre} {
+		if res := tx.Remove(p.Name); res != OK {
+			t.Errorf("Expected Remove() of %q to return %q, but got %q", p.Name, OK, res)
+		}
+	}
+	assertExist(fixture, pcre, t)
+
+	if res := tx.Commit(); res != OK {
+		t.Fatalf("Expected Commit() to return %q, but got %q", OK, res)
+	}
+	assertNotExist(fixture, pcre, t)
+	assertNotExist(fixture, nginx, t)
+}
+
+func TestTx_Abort(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	mysql := &Pkg{Name: "mysql"}
+
+	tx := fixture.Begin()
+	if res := tx.Index(mysql); res != OK {
+		t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+	}
+
+	if res := tx.Abort(); res != OK {
+		t.Errorf("Expected Abort() to return %q, but got %q", OK, res)
+	}
+	assertNotExist(fixture, mysql, t)
+
+	// a finished transaction can't be reused
+	if res := tx.Index(mysql); res != Error {
+		t.Errorf("Expected Index() to return %q, but got %q", Error, res)
+	}
+	if res := tx.Commit(); res != Error {
+		t.Errorf("Expected Commit() to return %q, but got %q", Error, res)
+	}
+}
+
+func TestTx_Commit_Fail_Conflict(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	pcre := &Pkg{Name: "pcre-8.38"}
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	nginx := &Pkg{Name: "nginx", Deps: []string{pcre.Name}}
+	seedRegistry(fixture, pcre)
+
+	tx := fixture.Begin()
+	for _, p := range []*Pkg{zlib, nginx} {
+		if res := tx.Index(p); res != OK {
+			t.Errorf("Expected Index() of %q to return %q, but got %q", p.Name, OK, res)
+		}
+	}
+
+	// another client removes a dependency before the transaction is committed
+	if res := fixture.Remove(pcre.Name); res != OK {
+		t.Fatalf("Expected Remove() to return %q, but got %q", OK, res)
+	}
+
+	if res := tx.Commit(); res != Fail {
+		t.Errorf("Expected Commit() to return %q, but got %q", Fail, res)
+	}
+
+	// expect none of the operations to be applied
+	assertNotExist(fixture, zlib, t)
+	assertNotExist(fixture, nginx, t)
+}
+
+func TestTx_Commit_Interleaved(t *testing.T) {
+	t.Parallel()
+
+	fixture := NewInMemoryIndexer()
+	zlib := &Pkg{Name: "zlib-1.2.8"}
+	pcre := &Pkg{Name: "pcre-8.38"}
+	seedRegistry(fixture, pcre)
+
+	tx := fixture.Begin()
+	if res := tx.Index(zlib); res != OK {
+		t.Errorf("Expected Index() to return %q, but got %q", OK, res)
+	}
+	if res := tx.Annotate(pcre.Name, &Metadata{Version: "8.38"}); res != OK {
+		t.Errorf("Expected Annotate() to return %q, but got %q", OK, res)
+	}
+
+	// another client indexes and annotates a staged package, before the transaction is committed
+	fixture.Index(zlib)
+	fixture.Annotate(zlib.Name, &Metadata{Version: "1.2.8"})
+
+	if res := tx.Commit(); res != OK {
+		t.Fatalf("Expected Commit() to return %q, but got %q", OK, res)
+	}
+	if p := fixture.Describe(zlib.Name); p == nil || p.Meta == nil || p.Meta.Version != "1.2.8" {
+		t.Errorf("Expected metadata of %q to be kept, but got %+v", zlib.Name, p)
+	}
+	if p := fixture.Describe(pcre.Name); p == nil || p.Meta == nil || p.Meta.Version != "8.38" {
+		t.Errorf("Expected metadata of %q to be replaced, but got %+v", pcre.Name, p)
+	}
+
+	// another client removes an annotated package, before the transaction is committed
+	tx = fixture.Begin()
+	if res := tx.Annotate(pcre.Name, &Metadata{Version: "8.45"}); res != OK {
+		t.Errorf("Expected Annotate() to return %q, but got %q", OK, res)
+	}
+	fixture.Remove(pcre.Name)
+
+	if res := tx.Commit(); res != Fail {
+		t.Errorf("Expected Commit() to return %q, but got %q", Fail, res)
+	}
+	assertNotExist(fixture, pcre, t)
+}
+
+func TestOverlay_Dependents(t *testing.T) {
+	t.Parallel()
+
+	// the counted dependents of the overlays of a transaction and a branch match a scan, as they and the main line change randomly
+	fixture := NewInMemoryIndexer()
+	b, err := fixture.Fork("rehearsal")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	tx := fixture.Begin()
+
+	r := rand.New(rand.NewSource(1))
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	random := func() *Pkg {
+		p := &Pkg{Name: names[r.Intn(len(names))]}
+		for _, d := range names {
+			if d < p.Name && r.Intn(2) == 0 {
+				p.Deps = append(p.Deps, d)
+			}
+		}
+		return p
+	}
+
+	targets := []Indexer{fixture, b, tx}
+	for n := 0; n < 2000; n++ {
+		target := targets[r.Intn(len(targets))]
+		if r.Intn(2) == 0 {
+			target.Index(random())
+		} else {
+			target.Remove(names[r.Intn(len(names))])
+		}
+
+		tx.recount()
+		for _, o := range []*overlay{b.o, b.base, tx.o} {
+			for _, name := range names {
+				if _, exist := o.lookup(name); !exist {
+					continue
+				}
+				if expected, actual := len(dependentsOf(o, name)), o.dependents(name); actual != expected {
+					t.Fatalf("Expected %q to have %d dependents after %d operations, but got %d", name, expected, n, actual)
+				}
+			}
+		}
+	}
+}
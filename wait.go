@@ -0,0 +1,121 @@
+package indexer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Waiter is implemented by indexers that can block until a package is indexed or removed.
+type Waiter interface {
+	Wait(name string, opts WaitOptions) string
+}
+
+// WaitOptions selects what Wait waits for.
+type WaitOptions struct {
+	// Removed waits until the package isn't indexed, rather than until it is.
+	Removed bool
+
+	// Timeout is how long Wait blocks at most. A zero Timeout doesn't block, like Query.
+	Timeout time.Duration
+}
+
+// waiters holds the channels of the calls of Wait, by the names of their packages.
+// Its lock is taken after i.m and the locks of the shards.
+type waiters struct {
+	sync.Mutex
+	m map[string]map[*waiter]bool
+
+	// n is the number of names waited for, such that notify doesn't lock ws when nobody waits, which is the common case.
+	n atomic.Int64
+}
+
+type waiter struct {
+	removed bool
+	c       chan struct{}
+}
+
+func newWaiters() *waiters {
+	return &waiters{m: map[string]map[*waiter]bool{}}
+}
+
+func (ws *waiters) add(name string, w *waiter) {
+	ws.Lock()
+	defer ws.Unlock()
+
+	if ws.m[name] == nil {
+		ws.m[name] = map[*waiter]bool{}
+	}
+	ws.m[name][w] = true
+	ws.n.Store(int64(len(ws.m)))
+}
+
+func (ws *waiters) delete(name string, w *waiter) {
+	ws.Lock()
+	defer ws.Unlock()
+
+	delete(ws.m[name], w)
+	if len(ws.m[name]) == 0 {
+		delete(ws.m, name)
+	}
+	ws.n.Store(int64(len(ws.m)))
+}
+
+// notify wakes up the calls of Wait on name that wait for it to be indexed, or removed.
+// A call of Wait that starts concurrently adds its waiter before it queries name, and thus sees the change.
+func (ws *waiters) notify(name string, removed bool) {
+	if ws.n.Load() == 0 {
+		return
+	}
+
+	ws.Lock()
+	defer ws.Unlock()
+
+	for w := range ws.m[name] {
+		if w.removed == removed {
+			delete(ws.m[name], w)
+			close(w.c)
+		}
+	}
+	if len(ws.m[name]) == 0 {
+		delete(ws.m, name)
+	}
+	ws.n.Store(int64(len(ws.m)))
+}
+
+// Wait blocks until name is indexed in i, or until it isn't if opts.Removed is set, or until opts.Timeout expires.
+// It returns OK if name is, or isn't, indexed, right away or before the timeout, and Fail if the timeout expires first.
+func (i *InMemoryIndexer) Wait(name string, opts WaitOptions) string {
+	expected := OK
+	if opts.Removed {
+		expected = Fail
+	}
+
+	// the waiter is added before name is queried, such that a change in between isn't missed
+	w := &waiter{removed: opts.Removed, c: make(chan struct{})}
+	i.waiters.add(name, w)
+	if i.Query(name) == expected {
+		i.waiters.delete(name, w)
+		return OK
+	}
+
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+
+		select {
+		case <-w.c:
+			return OK
+		case <-timer.C:
+		}
+	}
+
+	// name may have changed as the timeout expired
+	i.waiters.delete(name, w)
+	select {
+	case <-w.c:
+		return OK
+	default:
+		return Fail
+	}
+}
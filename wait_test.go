@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	i.Index(&Pkg{Name: "zlib"})
+
+	var tests = []struct {
+		name     string
+		opts     WaitOptions
+		expected string
+	}{
+		{name: "zlib", opts: WaitOptions{}, expected: OK},
+		{name: "zlib", opts: WaitOptions{Removed: true}, expected: Fail},
+		{name: "zlib", opts: WaitOptions{Removed: true, Timeout: 10 * time.Millisecond}, expected: Fail},
+		{name: "curl", opts: WaitOptions{}, expected: Fail},
+		{name: "curl", opts: WaitOptions{Timeout: 10 * time.Millisecond}, expected: Fail},
+		{name: "curl", opts: WaitOptions{Removed: true}, expected: OK},
+	}
+
+	for _, test := range tests {
+		if actual := i.Wait(test.name, test.opts); actual != test.expected {
+			t.Errorf("Expected response for %s with %+v to be %q, but got %q", test.name, test.opts, test.expected, actual)
+		}
+	}
+	if len(i.waiters.m) != 0 {
+		t.Errorf("Expected no waiters to be left, but got %v", i.waiters.m)
+	}
+}
+
+func TestWait_Changes(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	i.Index(&Pkg{Name: "zlib"})
+	indexed := make(chan string)
+	go func() {
+		indexed <- i.Wait("curl", WaitOptions{Timeout: 10 * time.Second})
+	}()
+	removed := make(chan string)
+	go func() {
+		removed <- i.Wait("zlib", WaitOptions{Removed: true, Timeout: 10 * time.Second})
+	}()
+
+	// wait until both calls block, and check that unrelated changes don't wake them up
+	for {
+		i.waiters.Lock()
+		n := len(i.waiters.m)
+		i.waiters.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	i.Index(&Pkg{Name: "openssl"})
+	select {
+	case res := <-indexed:
+		t.Fatalf("Expected Wait to block, but got %q", res)
+	default:
+	}
+
+	tx := i.Begin()
+	tx.Index(&Pkg{Name: "curl", Deps: []string{"openssl"}})
+	tx.Commit()
+	if res := <-indexed; res != OK {
+		t.Errorf("Expected response to be %q, but got %q", OK, res)
+	}
+
+	i.Remove("zlib")
+	if res := <-removed; res != OK {
+		t.Errorf("Expected response to be %q, but got %q", OK, res)
+	}
+}
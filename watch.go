@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	// ErrWatchLagging is an error message indicating a watch was stopped because its events weren't received fast enough.
+	ErrWatchLagging = "Watch fell behind the changes"
+
+	// ErrUnknownOp is an error message indicating a watch filter selects an unknown command.
+	ErrUnknownOp = "Unknown change command"
+
+	// watchBuffer is the number of events a watch holds for its receiver, besides the past events it resumes with. A watch whose buffer is full is stopped, rather than blocking the changes to the registry.
+	watchBuffer = 1024
+)
+
+// Watcher is implemented by indexers that stream the changes to their registry.
+type Watcher interface {
+	Watch(filter WatchFilter) (*Watch, error)
+}
+
+// WatchFilter selects the changes streamed by a watch.
+type WatchFilter struct {
+	// Pattern selects the packages whose names match it, like the patterns of Search. An empty Pattern selects all the packages.
+	Pattern string
+
+	// Op selects the changes of command Op, either "INDEX" or "REMOVE". An empty Op selects both.
+	Op string
+
+	// Selector selects the packages whose labels meet its requirements. The labels of a removed package are those it had when it was removed.
+	Selector Selector
+
+	// Resume streams the past changes after revision Since that are still kept in the journal, before the new ones. Without Resume, only the changes made after Watch is called are streamed.
+	Resume bool
+	Since  uint64
+}
+
+// Watch streams the changes to a registry that are selected by its filter, in order.
+type Watch struct {
+	// C receives the changes. It is closed once the watch is stopped.
+	C <-chan Change
+
+	c      chan Change
+	filter WatchFilter
+	re     *regexp.Regexp
+	i      *InMemoryIndexer
+	err    error
+}
+
+// Watch starts streaming the INDEX and REMOVE changes to the registry of i that are selected by filter. The ANNOTATE changes aren't streamed.
+// The watch is stopped if its receiver falls behind, in which case it can be resumed from the revision of the last change it received.
+// It returns an error if the pattern or the op of filter is invalid, or if filter resumes from a revision that is newer than the current one, or older than the oldest change kept in the journal.
+func (i *InMemoryIndexer) Watch(filter WatchFilter) (*Watch, error) {
+	w := &Watch{filter: filter, i: i}
+	if filter.Pattern != "" {
+		re, err := compilePattern(filter.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		w.re = re
+	}
+	if filter.Op != "" && filter.Op != opIndex && filter.Op != opRemove {
+		return nil, fmt.Errorf(ErrUnknownOp)
+	}
+
+	i.jm.Lock()
+	defer i.jm.Unlock()
+
+	var past []Change
+	if filter.Resume {
+		changes, err := i.journal.since(filter.Since)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range changes {
+			if w.selects(c) {
+				past = append(past, c)
+			}
+		}
+	}
+
+	w.c = make(chan Change, len(past)+watchBuffer)
+	w.C = w.c
+	for _, c := range past {
+		w.c <- c
+	}
+
+	i.watches[w] = true
+	return w, nil
+}
+
+// Stop stops w, and closes its channel. The changes already sent to the channel can still be received.
+func (w *Watch) Stop() {
+	w.i.jm.Lock()
+	defer w.i.jm.Unlock()
+
+	w.stop(nil)
+}
+
+// Err returns the reason why w was stopped, once its channel is closed: nil if it was stopped by Stop, or an error with the ErrWatchLagging message if its receiver fell behind.
+func (w *Watch) Err() error {
+	w.i.jm.Lock()
+	defer w.i.jm.Unlock()
+
+	return w.err
+}
+
+// stop removes w from the watches of its indexer, unless it is stopped already. The caller must hold w.i.jm.
+func (w *Watch) stop(err error) {
+	if !w.i.watches[w] {
+		return
+	}
+
+	delete(w.i.watches, w)
+	w.err = err
+	close(w.c)
+}
+
+func (w *Watch) selects(c Change) bool {
+	if c.Op != opIndex && c.Op != opRemove {
+		return false
+	}
+	if w.filter.Op != "" && c.Op != w.filter.Op {
+		return false
+	}
+	if w.re != nil && !w.re.MatchString(c.Pkg.Name) {
+		return false
+	}
+	return w.filter.Selector.selects(c.Pkg)
+}
+
+// publish sends c to the watches of i that select it. The watches whose buffers are full are stopped.
+// The caller must hold i.jm.
+func (i *InMemoryIndexer) publish(c Change) {
+	for w := range i.watches {
+		if !w.selects(c) {
+			continue
+		}
+
+		select {
+		case w.c <- c:
+		default:
+			w.stop(fmt.Errorf(ErrWatchLagging))
+		}
+	}
+}
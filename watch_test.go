@@ -0,0 +1,142 @@
+package indexer
+
+import (
+	"fmt"
+	"testing"
+)
+
+// received returns the changes of w sent so far, formatted like LOG lines.
+func received(w *Watch) []string {
+	var changes []string
+	for {
+		select {
+		case c, ok := <-w.C:
+			if !ok {
+				return changes
+			}
+			changes = append(changes, FormatChange(c))
+		default:
+			return changes
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	i.Index(&Pkg{Name: "zlib"})
+
+	all, err := i.Watch(WatchFilter{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	libs, err := i.Watch(WatchFilter{Pattern: "lib*", Op: opRemove})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	sel, err := ParseSelector("team=core")
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	core, err := i.Watch(WatchFilter{Selector: sel})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	i.Index(&Pkg{Name: "libidn2", Meta: &Metadata{Labels: map[string]string{"team": "core"}}})
+	i.Index(&Pkg{Name: "openssl", Deps: []string{"zlib"}})
+	i.Annotate("openssl", &Metadata{Version: "3.1.4"})
+	i.Remove("libidn2")
+	i.Remove("curl")
+
+	assertNames(t, "all changes", received(all), []string{
+		"2|INDEX|libidn2||label.team=core\n",
+		"3|INDEX|openssl|zlib\n",
+		"5|REMOVE|libidn2||label.team=core\n",
+	})
+	assertNames(t, "removed libraries", received(libs), []string{"5|REMOVE|libidn2||label.team=core\n"})
+	assertNames(t, "changes of core packages", received(core), []string{
+		"2|INDEX|libidn2||label.team=core\n",
+		"5|REMOVE|libidn2||label.team=core\n",
+	})
+
+	all.Stop()
+	all.Stop()
+	if _, ok := <-all.C; ok {
+		t.Error("Expected channel of stopped watch to be closed")
+	}
+	if err := all.Err(); err != nil {
+		t.Error("Unexpected error: ", err)
+	}
+	i.Index(&Pkg{Name: "curl", Deps: []string{"openssl"}})
+	if len(i.watches) != 2 {
+		t.Errorf("Expected 2 watches, but got %d", len(i.watches))
+	}
+}
+
+func TestWatch_Resume(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	i.Index(&Pkg{Name: "zlib"})
+	i.Index(&Pkg{Name: "openssl", Deps: []string{"zlib"}})
+	i.Remove("openssl")
+
+	w, err := i.Watch(WatchFilter{Resume: true, Since: 1})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer w.Stop()
+
+	i.Index(&Pkg{Name: "curl", Deps: []string{"zlib"}})
+	assertNames(t, "changes", received(w), []string{"2|INDEX|openssl|zlib\n", "3|REMOVE|openssl|zlib\n", "4|INDEX|curl|zlib\n"})
+
+	var tests = []struct {
+		filter   WatchFilter
+		expected string
+	}{
+		{filter: WatchFilter{Resume: true, Since: 5}, expected: ErrRevisionUnknown},
+		{filter: WatchFilter{Pattern: "lib[", Resume: true}, expected: ErrInvalidPattern},
+		{filter: WatchFilter{Op: opAnnotate}, expected: ErrUnknownOp},
+	}
+	for _, test := range tests {
+		if _, err := i.Watch(test.filter); err == nil || err.Error() != test.expected {
+			t.Errorf("Expected error for %+v to be %q, but got %v", test.filter, test.expected, err)
+		}
+	}
+}
+
+func TestWatch_Lagging(t *testing.T) {
+	t.Parallel()
+
+	i := NewInMemoryIndexer()
+	w, err := i.Watch(WatchFilter{})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+
+	// the receiver doesn't keep up, so the watch is stopped once its buffer is full
+	for k := 0; k <= watchBuffer; k++ {
+		i.Index(&Pkg{Name: fmt.Sprintf("pkg-%d", k)})
+	}
+
+	var last uint64
+	for c := range w.C {
+		last = c.Rev
+	}
+	if last != watchBuffer {
+		t.Errorf("Expected last change to be at revision %d, but got %d", watchBuffer, last)
+	}
+	if err := w.Err(); err == nil || err.Error() != ErrWatchLagging {
+		t.Errorf("Expected error to be %q, but got %v", ErrWatchLagging, err)
+	}
+
+	// the watch resumes from the last change it received
+	w, err = i.Watch(WatchFilter{Resume: true, Since: last})
+	if err != nil {
+		t.Fatal("Unexpected error: ", err)
+	}
+	defer w.Stop()
+	assertNames(t, "resumed changes", received(w), []string{fmt.Sprintf("%d|INDEX|pkg-%d|\n", watchBuffer+1, watchBuffer)})
+}